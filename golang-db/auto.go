@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"        // For recognizing a missing counter file
+	"fmt"           // For formatted error messages
+	"io/ioutil"     // For reading the counter file
+	"os"            // For checking whether an ID is taken
+	"path/filepath" // For file path operations
+	"strconv"       // For encoding the counter
+	"strings"       // For trimming the stored counter
+)
+
+// Name of the file, at the top of a collection, that holds the last ID handed out by InsertAuto
+const sequenceFileName = ".sequence"
+
+// Method to insert a record under the next sequential ID of its collection, returning the ID
+// IDs are 1, 2, 3... and the last one handed out is kept in the collection's .sequence file, so
+// numbering carries on after a restart. IDs are taken while holding the collection lock, so
+// concurrent calls never get the same one; an ID whose insert failed is not reused. IDs already
+// taken by a record (e.g. inserted by hand, or after ReplaceCollection reset the counter) are skipped.
+func (d *Driver) InsertAuto(collection string, v interface{}) (string, error) {
+	id, err := d.nextID(collection)
+	if err != nil {
+		return "", err
+	}
+	if err := d.Insert(collection, id, v); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// Method to take the next free sequential ID of a collection and persist the counter
+func (d *Driver) nextID(collection string) (string, error) {
+	// Refuse to write when the driver was opened read-only
+	if d.readOnly {
+		return "", ErrReadOnly
+	}
+
+	// Validate that a collection name is provided
+	if collection == "" {
+		return "", fmt.Errorf("%w - no place to save record", ErrMissingCollection)
+	}
+
+	mutex, err := d.lockCollection(collection)
+	if err != nil {
+		return "", err
+	}
+	defer mutex.Unlock()
+
+	// Read the counter back from disk, starting from 0 for a new collection
+	path := filepath.Join(d.dir, collection, sequenceFileName)
+	last := 0
+	b, err := ioutil.ReadFile(path)
+	if err == nil {
+		if last, err = strconv.Atoi(strings.TrimSpace(string(b))); err != nil {
+			return "", fmt.Errorf("unable to read %v counter: %w", collection, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+
+	// Move past any ID that already has a record
+	var id string
+	for {
+		last++
+		id = strconv.Itoa(last)
+		record, err := d.resolvePath(collection, id)
+		if err != nil {
+			return "", err
+		}
+		if _, err := os.Stat(record); errors.Is(err, os.ErrNotExist) {
+			break
+		} else if err != nil {
+			return "", err
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	if err := writeAtomic(path, []byte(id+"\n")); err != nil {
+		return "", err
+	}
+	d.replicate(path)
+	return id, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"archive/tar"   // For packing the database files into one stream
+	"compress/gzip" // For compressing the archive
+	"context"       // For cancelling a backup
+	"errors"        // For skipping files deleted mid-backup
+	"io"            // For streaming file contents
+	"io/ioutil"     // For listing directories
+	"os"            // For opening database files
+	"path/filepath" // For file path operations
+	"strings"       // For skipping temporary files and staging directories
+)
+
+// Method to stream a backup of the whole database to w as a gzipped tar archive
+// Every file is included (records, their sidecars, history, indexes, line collections and
+// namespaces) under its path relative to the database directory, so extracting the archive gives a
+// directory New can open. Temporary files and ReplaceCollection staging directories are skipped.
+// The files are listed first, then progress is called with how many have been archived so far and
+// how many there are in total, once before the first file and again after each one.
+// No lock is held: each file is archived as it was when it was opened, but records written during
+// the backup may or may not be included, so use Snapshot first if a point-in-time copy is needed.
+// When ctx is cancelled the backup stops without finishing the archive, so a partial backup fails to
+// extract instead of passing for a complete one, and ctx.Err() is returned.
+func (d *Driver) BackupTo(ctx context.Context, w io.Writer, progress func(done, total int)) error {
+	files, err := listTree(d.dir, "")
+	if err != nil {
+		return err
+	}
+	total := len(files)
+	if progress != nil {
+		progress(0, total)
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for i, rel := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := addToBackup(ctx, tw, d.dir, rel); err != nil {
+			return err
+		}
+		if progress != nil {
+			progress(i+1, total)
+		}
+	}
+
+	// Write the archive trailers only once everything is in
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Helper function to list the files below a directory, relative to root
+// Symlinks are followed, so linked collections and records are archived like regular ones
+func listTree(root, rel string) ([]string, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(root, rel))
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		path := filepath.Join(rel, entry.Name())
+		info, err := followLink(filepath.Join(root, path), entry)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case info.IsDir() && strings.Contains(entry.Name(), ".replace."):
+			continue // Half-built collections are not part of the database
+		case info.IsDir():
+			nested, err := listTree(root, path)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, nested...)
+		case info.Mode().IsRegular() && !strings.HasSuffix(entry.Name(), ".tmp"):
+			files = append(files, path)
+		}
+	}
+	return files, nil
+}
+
+// Helper function to add one file to a backup archive
+// Exactly the size the file had when it was opened is copied, so an append to a line collection
+// during the backup can't make the entry longer than its header says
+func addToBackup(ctx context.Context, tw *tar.Writer, root, rel string) error {
+	file, err := os.Open(filepath.Join(root, rel))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil // Deleted since the files were listed
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	fi, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := tar.FileInfoHeader(fi, "")
+	if err != nil {
+		return err
+	}
+	header.Name = filepath.ToSlash(rel)
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+
+	_, err = io.CopyN(tw, &contextReader{ctx: ctx, r: file}, fi.Size())
+	return err
+}
+
+// Reader that stops with the context's error once it is cancelled, so a large file doesn't hold up
+// a cancelled backup
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
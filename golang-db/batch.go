@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"           // For formatted error messages
+	"os"            // For creating directories and cleaning up staged files
+	"path/filepath" // For file path operations
+	"sort"          // For writing records in a deterministic order
+)
+
+// Method to insert many records into a collection in one call, keyed by resource name
+// The collection is locked once and its directories created once, and each record is still written
+// through its own temporary file. Records are written in resource name order; if one fails, the
+// error names it and the records before it stay written. Use InsertBatchAtomic for all-or-nothing.
+func (d *Driver) InsertBatch(collection string, records map[string]interface{}) error {
+	return d.insertBatch(collection, records, false)
+}
+
+// Method to insert many records all-or-nothing
+// Every record's temporary file is written before any of them is renamed into place, so a record
+// that fails to encode or write leaves the collection untouched. Only a failing rename, after all
+// the data is safely on disk, can still leave the batch partly applied.
+func (d *Driver) InsertBatchAtomic(collection string, records map[string]interface{}) error {
+	return d.insertBatch(collection, records, true)
+}
+
+// Struct holding one record of a batch on its way to disk
+type batchRecord struct {
+	resource string
+	path     string // Final path of the record file
+	b        []byte // Encoded record
+	stored   []byte // Bytes written to the temporary file
+}
+
+// Method doing the work of InsertBatch and InsertBatchAtomic
+func (d *Driver) insertBatch(collection string, records map[string]interface{}, atomic bool) error {
+	// Refuse to write when the driver was opened read-only
+	if d.readOnly {
+		return ErrReadOnly
+	}
+
+	// Validate that a collection name is provided
+	if collection == "" {
+		return fmt.Errorf("%w - no place to save records", ErrMissingCollection)
+	}
+
+	names := make([]string, 0, len(records))
+	for name := range records {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Encode every record before taking the lock, as Insert does
+	batch := make([]batchRecord, 0, len(names))
+	for _, resource := range names {
+		path, err := d.resolvePath(collection, resource)
+		if err != nil {
+			return fmt.Errorf("unable to write %v: %w", resource, err)
+		}
+		b, err := d.marshalRecord(records[resource])
+		if err != nil {
+			return fmt.Errorf("unable to write %v: %w", resource, err)
+		}
+		if err := d.checkRecordSize(path, int64(len(b))); err != nil {
+			return fmt.Errorf("unable to write %v: %w", resource, err)
+		}
+		batch = append(batch, batchRecord{resource: resource, path: path, b: b})
+	}
+
+	mutex, err := d.lockCollection(collection)
+	if err != nil {
+		return err
+	}
+	defer mutex.Unlock()
+
+	// Create each directory once (only the collection itself, unless records are sharded)
+	dirs := make(map[string]bool)
+	for _, record := range batch {
+		dir := filepath.Dir(record.path)
+		if dirs[dir] {
+			continue
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		dirs[dir] = true
+	}
+
+	if !atomic {
+		for _, record := range batch {
+			if err := d.writeKey(record.path, record.resource); err != nil {
+				return fmt.Errorf("unable to write %v: %w", record.resource, err)
+			}
+			if err := d.writeRecord(record.path, record.b); err != nil {
+				return fmt.Errorf("unable to write %v: %w", record.resource, err)
+			}
+		}
+		return nil
+	}
+
+	// Write every temporary file first, removing them all again if one fails
+	for i := range batch {
+		record := &batch[i]
+		err := d.checkKey(record.path, record.resource)
+		if err == nil {
+			record.stored, err = d.stageRecord(record.path, record.b)
+		}
+		if err != nil {
+			removeStaged(batch[:i+1])
+			return fmt.Errorf("unable to write %v: %w", record.resource, err)
+		}
+	}
+
+	// Then move them all into place
+	for i, record := range batch {
+		err := d.writeKey(record.path, record.resource)
+		if err == nil {
+			err = d.commitRecord(record.path, record.b, record.stored)
+		}
+		if err != nil {
+			removeStaged(batch[i+1:])
+			return fmt.Errorf("unable to write %v: %w", record.resource, err)
+		}
+	}
+	return nil
+}
+
+// Helper function to remove the temporary files of batch records that were never moved into place
+func removeStaged(batch []batchRecord) {
+	for _, record := range batch {
+		os.Remove(record.path + ".tmp")
+		os.Remove(checksumPath(record.path) + ".tmp")
+	}
+}
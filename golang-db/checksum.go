@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/sha256" // For computing record checksums
+	"encoding/hex"  // For storing checksums as text
+	"errors"        // For the ErrCorrupted sentinel
+	"fmt"           // For formatted error messages
+	"io/ioutil"     // For reading checksum sidecars
+	"os"            // For checking whether a sidecar exists
+	"path/filepath" // For naming the offending file in errors
+	"strings"       // For trimming the stored checksum
+)
+
+// Error returned when a record's contents don't match its stored checksum
+var ErrCorrupted = errors.New("record is corrupted - checksum mismatch")
+
+// Same error as ErrCorrupted, so errors.Is matches either name
+//
+// Deprecated: use ErrCorrupted.
+var ErrChecksumMismatch = ErrCorrupted
+
+// Error returned by ReadAll when some records in a collection failed checksum verification
+// The records that passed are still returned; errors.Is(err, ErrCorrupted) reports true
+type CorruptedRecordsError struct {
+	Collection string   // Collection that was being read
+	Resources  []string // Names of the records that failed verification
+}
+
+func (e *CorruptedRecordsError) Error() string {
+	return fmt.Sprintf("%d record(s) in %v failed checksum verification: %v", len(e.Resources), e.Collection, strings.Join(e.Resources, ", "))
+}
+
+func (e *CorruptedRecordsError) Is(target error) bool {
+	return target == ErrCorrupted
+}
+
+// Helper function to build the path of a record's checksum sidecar
+func checksumPath(path string) string {
+	return path + ".sum"
+}
+
+// Helper function to compute the hex-encoded SHA-256 of a record's contents
+func checksum(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// Helper function to write the checksum for a record's new contents to the sidecar's temporary file
+// It is written before the record itself and only renamed into place afterwards by commitChecksum,
+// so a crash between the two renames leaves the new checksum where verifyChecksum can find it
+func stageChecksum(path string, b []byte) error {
+	return ioutil.WriteFile(checksumPath(path)+".tmp", []byte(checksum(b)+"\n"), 0644)
+}
+
+// Helper function to move a staged checksum into place once the record has been written
+func commitChecksum(path string) error {
+	return os.Rename(checksumPath(path)+".tmp", checksumPath(path))
+}
+
+// Helper function to verify a record against its checksum sidecar
+// Records written before checksums were enabled have no sidecar and are accepted as-is. A record
+// that doesn't match its sidecar is still accepted if it matches a staged checksum, as that means
+// the write finished but was interrupted before the sidecar was renamed into place.
+func verifyChecksum(path string, b []byte) error {
+	stored, err := ioutil.ReadFile(checksumPath(path))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if strings.TrimSpace(string(stored)) != checksum(b) {
+		if staged, err := ioutil.ReadFile(checksumPath(path) + ".tmp"); err == nil && strings.TrimSpace(string(staged)) == checksum(b) {
+			return nil
+		}
+		return fmt.Errorf("%v: %w", filepath.Base(path), ErrCorrupted)
+	}
+	return nil
+}
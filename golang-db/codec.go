@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json" // For the default record format
+	"path/filepath" // For extracting resource names from file paths
+	"strings"       // For matching and trimming file extensions
+)
+
+// Interface for the format records are stored in on disk
+// Extension is the file suffix records get, including the dot (e.g. ".json" or ".yaml").
+// Only Insert, Read and the listing methods go through the codec; features that look inside
+// records (Patch and the other in-place updates, indexes, Project, Migrate, StrictDecode,
+// ExportCollection and line collections) work on JSON and need the default codec.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(b []byte, v interface{}) error
+	Extension() string
+}
+
+// Codec storing records as pretty-printed JSON files, the default
+var JSONCodec Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	b, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+
+	// Append a newline character to the JSON data for readability
+	return append(b, byte('\n')), nil
+}
+
+func (jsonCodec) Unmarshal(b []byte, v interface{}) error {
+	return json.Unmarshal(b, v)
+}
+
+func (jsonCodec) Extension() string {
+	return ".json"
+}
+
+// Helper function to report whether a file in a collection is a record, as opposed to a temporary file
+func (d *Driver) isRecordFile(path string) bool {
+	return strings.HasSuffix(path, d.ext)
+}
+
+// Helper function to turn a record file path back into its resource name
+func (d *Driver) resourceName(path string) string {
+	return strings.TrimSuffix(filepath.Base(path), d.ext)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"         // For compressing records in memory
+	"compress/gzip" // For the compressed record format
+	"fmt"           // For formatted error messages
+	"io"            // For handing out decompressing readers
+	"io/ioutil"     // For reading decompressed records
+	"os"            // For the record file behind a stream
+	"path/filepath" // For naming the offending file in errors
+)
+
+// Suffix added after the codec's extension when Options.Compress is set (e.g. users/John.json.gz)
+const compressedExt = ".gz"
+
+// Method to turn a record's encoded bytes into what is stored on disk
+// With compression disabled the bytes are returned unchanged
+func (d *Driver) compressRecord(b []byte) ([]byte, error) {
+	if !d.compress {
+		return b, nil
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(b); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Method to turn the stored bytes of a record file back into its encoded bytes
+// With compression disabled the bytes are returned unchanged
+func (d *Driver) decompressRecord(path string, b []byte) ([]byte, error) {
+	if !d.compress {
+		return b, nil
+	}
+
+	// Stop just past the size limit, so a small file can't inflate into a huge record in memory
+	gz, err := gzip.NewReader(bytes.NewReader(b))
+	if err == nil {
+		var r io.Reader = gz
+		if d.maxRecordBytes > 0 {
+			r = io.LimitReader(gz, int64(d.maxRecordBytes)+1)
+		}
+		b, err = ioutil.ReadAll(r)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to decompress %v: %w", filepath.Base(path), err)
+	}
+	return b, nil
+}
+
+// Reader that decompresses a record file and closes the file along with it
+type compressedStream struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (s *compressedStream) Close() error {
+	s.Reader.Close()
+	return s.file.Close()
+}
+
+// Method to wrap an open record file so it reads the record's encoded bytes
+// With compression disabled the file is returned as it is
+func (d *Driver) decompressStream(file *os.File) (io.ReadCloser, error) {
+	if !d.compress {
+		return file, nil
+	}
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("unable to decompress %v: %w", filepath.Base(file.Name()), err)
+	}
+	stream := &compressedStream{Reader: gz, file: file}
+	if d.maxRecordBytes > 0 {
+		return &limitedStream{ReadCloser: stream, d: d, path: file.Name()}, nil
+	}
+	return stream, nil
+}
+
+// Reader that fails with ErrRecordTooLarge once a decompressed record reads past Options.MaxRecordBytes
+type limitedStream struct {
+	io.ReadCloser
+	d    *Driver
+	path string
+	read int64 // Decompressed bytes handed out so far
+}
+
+func (s *limitedStream) Read(p []byte) (int, error) {
+	// Never read more than one byte past the limit, which is enough to tell it was crossed
+	if room := int64(s.d.maxRecordBytes) + 1 - s.read; int64(len(p)) > room {
+		p = p[:room]
+	}
+	n, err := s.ReadCloser.Read(p)
+	s.read += int64(n)
+	if over := s.read - int64(s.d.maxRecordBytes); over > 0 {
+		return n - int(over), s.d.checkRecordSize(s.path, s.read)
+	}
+	return n, err
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json" // For inspecting JSON error types
+	"errors"        // For unwrapping the underlying decode error
+	"fmt"           // For formatted error messages
+)
+
+// Error returned when a stored record can't be decoded
+// It names the collection and resource so the offending file can be located, and carries the
+// byte offset reported by encoding/json (0 when the error doesn't have one)
+type DecodeError struct {
+	Collection string // Collection holding the bad record
+	Resource   string // Name of the bad record
+	Offset     int64  // Byte offset in the file where decoding failed
+	Err        error  // Underlying encoding/json error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("unable to decode record %v/%v at byte %d: %v", e.Collection, e.Resource, e.Offset, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// Helper function to wrap a decode failure with the record it came from
+func decodeError(collection, resource string, err error) error {
+	var offset int64
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset = syntaxErr.Offset
+	case errors.As(err, &typeErr):
+		offset = typeErr.Offset
+	}
+
+	return &DecodeError{
+		Collection: collection,
+		Resource:   resource,
+		Offset:     offset,
+		Err:        err,
+	}
+}
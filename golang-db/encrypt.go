@@ -0,0 +1,57 @@
+package main
+
+import (
+	"crypto/aes"    // For the AES block cipher
+	"crypto/cipher" // For GCM authenticated encryption
+	"crypto/rand"   // For generating nonces
+	"errors"        // For the decryption error sentinel
+	"fmt"           // For formatted error messages
+	"path/filepath" // For naming the offending file in errors
+)
+
+// Error returned when a record file can't be decrypted, because the key is wrong or the file is corrupted
+var ErrDecryptionFailed = errors.New("unable to decrypt record - wrong key or corrupted file")
+
+// Helper function to set up AES-256-GCM for Options.EncryptionKey
+func newRecordCipher(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("EncryptionKey must be 32 bytes for AES-256, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Method to encrypt a record's bytes, with a random nonce prepended to the sealed data
+// With encryption disabled the bytes are returned unchanged
+func (d *Driver) encryptRecord(b []byte) ([]byte, error) {
+	if d.aead == nil {
+		return b, nil
+	}
+
+	nonce := make([]byte, d.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return d.aead.Seal(nonce, nonce, b, nil), nil
+}
+
+// Method to decrypt the stored bytes of a record file
+// With encryption disabled the bytes are returned unchanged
+func (d *Driver) decryptRecord(path string, b []byte) ([]byte, error) {
+	if d.aead == nil {
+		return b, nil
+	}
+
+	size := d.aead.NonceSize()
+	if len(b) < size {
+		return nil, fmt.Errorf("%v: %w", filepath.Base(path), ErrDecryptionFailed)
+	}
+	plain, err := d.aead.Open(nil, b[:size], b[size:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", filepath.Base(path), ErrDecryptionFailed)
+	}
+	return plain, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes" // For trimming the trailing newline of each record
+	"fmt"   // For formatted error messages
+	"io"    // For writing the export to any destination
+)
+
+// Method to export a collection as a single JSON array
+// The array is streamed to w one record at a time, so memory use stays flat however large the
+// collection is and the export can be piped straight into a file or a network connection.
+// Records are written as stored, in the same order as ReadAll.
+func (d *Driver) ExportCollection(collection string, w io.Writer) error {
+	// Validate that a collection name is provided
+	if collection == "" {
+		return fmt.Errorf("%w - unable to export records", ErrMissingCollection)
+	}
+
+	// Construct the directory path for the collection and check that it exists
+	dir, err := d.collectionDir(collection)
+	if err != nil {
+		return err
+	}
+
+	files, err := d.recordFiles(dir)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+
+	first := true
+	for _, file := range files {
+		if !d.isRecordFile(file) {
+			continue // Skip temporary files
+		}
+
+		// Read the record, verifying its checksum if enabled
+		b, err := d.readRecord(file)
+		if err != nil {
+			return err
+		}
+
+		// Separate records with a comma, putting each on its own line
+		separator := ",\n"
+		if first {
+			separator, first = "\n", false
+		}
+		if _, err := io.WriteString(w, separator); err != nil {
+			return err
+		}
+		if _, err := w.Write(bytes.TrimSpace(b)); err != nil {
+			return err
+		}
+	}
+
+	_, err = io.WriteString(w, "\n]\n")
+	return err
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors" // For combining the errors of unreadable records
+	"fmt"    // For formatted error messages
+	"os"     // For skipping records deleted mid-scan
+)
+
+// Method to read the records of a collection that match a predicate, in ReadAll order
+// Records are read and tested one at a time, so only the matches are kept in memory. With failFast
+// set, the first record that can't be read stops the scan and its error is returned; otherwise
+// unreadable records are skipped and, once the scan is done, the matches are returned along with
+// an error joining one entry per skipped record. Leftover temporary files are skipped.
+func (d *Driver) Find(collection string, match func(raw []byte) bool, failFast bool) ([]string, error) {
+	return d.find(collection, match, failFast, 0)
+}
+
+// Method to read the first record of a collection, in ReadAll order, that matches a predicate
+// The scan stops at the first match, so the remaining records are never read. Finding nothing is
+// reported as found=false with no error; a record that can't be read stops the scan with its error.
+func (d *Driver) FindOne(collection string, match func(raw []byte) bool) (string, bool, error) {
+	matches, err := d.find(collection, match, true, 1)
+	if err != nil || len(matches) == 0 {
+		return "", false, err
+	}
+	return matches[0], true, nil
+}
+
+// Method doing the work of Find, stopping once limit records matched (0 means no limit)
+func (d *Driver) find(collection string, match func(raw []byte) bool, failFast bool, limit int) ([]string, error) {
+	// Validate that a collection name is provided
+	if collection == "" {
+		return nil, fmt.Errorf("%w - unable to find records", ErrMissingCollection)
+	}
+
+	// Hold the collection's read lock, so no record is read halfway through a write
+	mutex, err := d.rlockCollection(collection)
+	if err != nil {
+		return nil, err
+	}
+	defer mutex.RUnlock()
+
+	// Construct the directory path for the collection and check that it exists
+	dir, err := d.collectionDir(collection)
+	if err != nil {
+		return nil, d.emptyIfMissing(err)
+	}
+
+	files, err := d.recordFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []string
+	var errs []error
+	for _, file := range files {
+		if !d.isRecordFile(file) {
+			continue // Skip temporary files
+		}
+
+		b, err := d.readRecord(file)
+		if errors.Is(err, os.ErrNotExist) {
+			continue // Deleted since the directory was listed
+		}
+		if err != nil {
+			err = fmt.Errorf("%v/%v: %w", collection, d.keyOf(file), err)
+			if failFast {
+				return nil, err
+			}
+			errs = append(errs, err)
+			continue
+		}
+
+		if match(b) {
+			matches = append(matches, string(b))
+			if limit > 0 && len(matches) >= limit {
+				break
+			}
+		}
+	}
+	return matches, errors.Join(errs...)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"        // For skipping records deleted mid-walk
+	"io/ioutil"     // For listing the collections of the database
+	"os"            // For following symlinked collections
+	"path/filepath" // For file path operations
+	"strings"       // For recognizing staging directories
+)
+
+// Method to visit every record of every collection in the database
+// Collections are visited in name order and records in ReadAll order. Each record is read under its
+// collection's read lock, but fn is called with the lock released, so fn may write to the database (e.g.
+// to rewrite the record it was given). The walk stops at the first error fn returns.
+func (d *Driver) ForEach(fn func(collection, resource string, raw []byte) error) error {
+	collections, err := d.collections()
+	if err != nil {
+		return err
+	}
+
+	for _, collection := range collections {
+		dir := filepath.Join(d.dir, collection)
+		files, err := d.recordFiles(dir)
+		if err != nil {
+			return err
+		}
+
+		for _, file := range files {
+			if !d.isRecordFile(file) {
+				continue // Skip temporary files
+			}
+
+			mutex, err := d.rlockCollection(collection)
+			if err != nil {
+				return err
+			}
+			b, err := d.readRecord(file)
+			mutex.RUnlock()
+
+			// A record deleted since the directory was listed is simply gone, not an error
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			if err := fn(collection, d.keyOf(file), b); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Helper function to list the collections of the database, in name order
+// Staging directories left behind by ReplaceCollection and hidden directories such as the one
+// holding namespaces are not collections and are skipped
+func (d *Driver) collections() ([]string, error) {
+	entries, err := ioutil.ReadDir(d.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		info, err := followLink(filepath.Join(d.dir, entry.Name()), entry)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() || strings.Contains(entry.Name(), ".replace.") || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		names = append(names, entry.Name())
+	}
+	return names, nil
+}
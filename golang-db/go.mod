@@ -2,4 +2,9 @@ module github.com/yashjhaveri05/golang-db
 
 go 1.22.6
 
-require github.com/jcelliott/lumber v0.0.0-20160324203708-dd349441af25 // indirect
+require (
+	github.com/fsnotify/fsnotify v1.9.0
+	github.com/jcelliott/lumber v0.0.0-20160324203708-dd349441af25
+)
+
+require golang.org/x/sys v0.13.0 // indirect
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bytes"         // For decoding index files with exact numbers
+	"encoding/json" // For reading fields out of records and storing indexes
+	"errors"        // For the index error sentinel
+	"fmt"           // For formatted error messages
+	"io/ioutil"     // For listing and reading index files
+	"math/big"      // For comparing numbers of any size exactly
+	"os"            // For file and directory operations
+	"path/filepath" // For file path operations
+	"sort"          // For keeping index entries in order
+	"strings"       // For trimming file extensions
+)
+
+// Name of the directory, at the top of a collection, that holds its sorted indexes
+// Each index is a single file named after its field (e.g. users/.indexes/Age.json)
+const indexesDirName = ".indexes"
+
+// Error returned by Range and DropIndex when the field has no index
+var ErrNoIndex = errors.New("no index on field")
+
+// Struct representing one record in a sorted index
+type indexEntry struct {
+	Resource string      `json:"resource"`
+	Value    interface{} `json:"value"` // Either a string or a json.Number
+}
+
+// Helper function to build the path of the index file for a field
+func indexPath(collectionDir, field string) string {
+	return filepath.Join(collectionDir, indexesDirName, field+".json")
+}
+
+// Method to create a sorted index on a top-level field of a collection's records, or rebuild it
+// Once created, the index is kept up to date by every write to the collection and can be queried
+// with Range. Only string and number values are indexed; records where the field is missing or
+// holds anything else are left out. Calling it again rebuilds the index from the records, which is
+// the way to recover an index that went out of step, e.g. after records were edited by hand.
+func (d *Driver) CreateIndex(collection, field string) error {
+	// Refuse to write when the driver was opened read-only
+	if d.readOnly {
+		return ErrReadOnly
+	}
+
+	// Validate that a collection name is provided
+	if collection == "" {
+		return fmt.Errorf("%w - unable to create index", ErrMissingCollection)
+	}
+
+	// The field name doubles as the index file name, so it has to be a safe one
+	if _, err := SafeKeys(field); err != nil {
+		return fmt.Errorf("Invalid Field - unable to create index: %w", err)
+	}
+
+	mutex, err := d.lockCollection(collection)
+	if err != nil {
+		return err
+	}
+	defer mutex.Unlock()
+
+	dir, err := d.collectionDir(collection)
+	if err != nil {
+		return err
+	}
+	return d.buildIndex(dir, field)
+}
+
+// Method to drop the sorted index on a field
+func (d *Driver) DropIndex(collection, field string) error {
+	// Refuse to write when the driver was opened read-only
+	if d.readOnly {
+		return ErrReadOnly
+	}
+
+	// Validate that a collection name is provided
+	if collection == "" {
+		return fmt.Errorf("%w - unable to drop index", ErrMissingCollection)
+	}
+
+	mutex, err := d.lockCollection(collection)
+	if err != nil {
+		return err
+	}
+	defer mutex.Unlock()
+
+	path := indexPath(filepath.Join(d.dir, collection), field)
+	if err := os.Remove(path); errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%v/%v: %w", collection, field, ErrNoIndex)
+	} else if err != nil {
+		return err
+	}
+	d.replicate(path)
+	return nil
+}
+
+// Method to read the records whose indexed field lies between min and max (both inclusive), in order
+// Bounds that look like numbers are compared numerically, anything else as strings; numbers sort
+// before strings. An empty bound leaves that end open, and a limit of 0 or less returns every match.
+// Like ReadAll, it holds the collection's read lock, so the index and records are read in step.
+func (d *Driver) Range(collection, field string, min, max string, limit int) ([]string, error) {
+	// Validate that a collection name is provided
+	if collection == "" {
+		return nil, fmt.Errorf("%w - unable to read range", ErrMissingCollection)
+	}
+
+	mutex, err := d.rlockCollection(collection)
+	if err != nil {
+		return nil, err
+	}
+	defer mutex.RUnlock()
+
+	dir, err := d.collectionDir(collection)
+	if err != nil {
+		return nil, d.emptyIfMissing(err)
+	}
+
+	entries, err := loadIndex(indexPath(dir, field))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%v/%v: %w", collection, field, ErrNoIndex)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Jump straight to the first entry at or above min
+	start := 0
+	if min != "" {
+		lower := boundValue(min)
+		start = sort.Search(len(entries), func(i int) bool {
+			return compareIndexValues(entries[i].Value, lower) >= 0
+		})
+	}
+
+	var records []string
+	for _, entry := range entries[start:] {
+		if max != "" && compareIndexValues(entry.Value, boundValue(max)) > 0 {
+			break
+		}
+		if limit > 0 && len(records) >= limit {
+			break
+		}
+
+		record, err := d.resolvePath(collection, entry.Resource)
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.readRecord(record)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, string(b))
+	}
+	return records, nil
+}
+
+// Method to build the index on a field from the records of a collection directory
+// The caller must hold the collection lock
+func (d *Driver) buildIndex(dir, field string) error {
+	files, err := d.recordFiles(dir)
+	if err != nil {
+		return err
+	}
+
+	var entries []indexEntry
+	for _, file := range files {
+		if !d.isRecordFile(file) {
+			continue // Skip temporary files
+		}
+		b, err := d.readRecord(file)
+		if err != nil {
+			return err
+		}
+		if value, ok := indexValue(b, field); ok {
+			entries = append(entries, indexEntry{Resource: d.keyOf(file), Value: value})
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return compareEntries(entries[i], entries[j]) < 0
+	})
+	return d.saveIndex(indexPath(dir, field), entries)
+}
+
+// Method to bring every index of a record's collection up to date after the record was written
+// b holds the record's new contents, or nil when it was deleted. The caller must hold the collection lock.
+func (d *Driver) updateIndexes(record, resource string, b []byte) error {
+	dir := d.collectionRoot(record)
+	fields, err := listIndexes(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, field := range fields {
+		path := indexPath(dir, field)
+		entries, err := loadIndex(path)
+		if err != nil {
+			return err
+		}
+
+		// Drop the record's old entry, then put the new one in its place in the order
+		kept := entries[:0]
+		for _, entry := range entries {
+			if entry.Resource != resource {
+				kept = append(kept, entry)
+			}
+		}
+		if value, ok := indexValue(b, field); ok {
+			entry := indexEntry{Resource: resource, Value: value}
+			i := sort.Search(len(kept), func(i int) bool {
+				return compareEntries(kept[i], entry) > 0
+			})
+			kept = append(kept, indexEntry{})
+			copy(kept[i+1:], kept[i:])
+			kept[i] = entry
+		}
+
+		if err := d.saveIndex(path, kept); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Helper function to list the indexed fields of a collection directory
+func listIndexes(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(dir, indexesDirName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var fields []string
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) != ".json" {
+			continue // Skip temporary files
+		}
+		fields = append(fields, strings.TrimSuffix(entry.Name(), ".json"))
+	}
+	return fields, nil
+}
+
+// Helper function to read an index file, keeping numbers exact
+func loadIndex(path string) ([]indexEntry, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var entries []indexEntry
+	if err := dec.Decode(&entries); err != nil {
+		return nil, fmt.Errorf("unable to decode index %v: %w", filepath.Base(path), err)
+	}
+	return entries, nil
+}
+
+// Method to write an index file atomically and mirror it to the replica
+func (d *Driver) saveIndex(path string, entries []indexEntry) error {
+	if entries == nil {
+		entries = []indexEntry{}
+	}
+	b, err := json.MarshalIndent(entries, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := writeAtomic(path, append(b, '\n')); err != nil {
+		return err
+	}
+	d.replicate(path)
+	return nil
+}
+
+// Helper function to pull the indexable value of a field out of a record
+// It reports false for deleted records and for values that are neither strings nor numbers
+func indexValue(b []byte, field string) (interface{}, bool) {
+	var fields map[string]json.RawMessage
+	if b == nil || json.Unmarshal(b, &fields) != nil {
+		return nil, false
+	}
+	raw, ok := fields[field]
+	if !ok {
+		return nil, false
+	}
+
+	value, err := decodeValue(raw)
+	if err != nil {
+		return nil, false
+	}
+	switch value.(type) {
+	case string, json.Number:
+		return value, true
+	}
+	return nil, false
+}
+
+// Helper function to turn a Range bound into a value comparable with index entries
+func boundValue(s string) interface{} {
+	if value, err := decodeValue([]byte(s)); err == nil {
+		if number, ok := value.(json.Number); ok {
+			return number
+		}
+	}
+	return s
+}
+
+// Helper function to order two index entries, by value and then by resource name
+func compareEntries(a, b indexEntry) int {
+	if c := compareIndexValues(a.Value, b.Value); c != 0 {
+		return c
+	}
+	return strings.Compare(a.Resource, b.Resource)
+}
+
+// Helper function to order two indexed values: numbers numerically, then strings lexically
+func compareIndexValues(a, b interface{}) int {
+	an, aIsNumber := a.(json.Number)
+	bn, bIsNumber := b.(json.Number)
+	switch {
+	case aIsNumber && bIsNumber:
+		af, _ := new(big.Float).SetString(string(an))
+		bf, _ := new(big.Float).SetString(string(bn))
+		if af != nil && bf != nil {
+			return af.Cmp(bf)
+		}
+		return strings.Compare(string(an), string(bn))
+	case aIsNumber:
+		return -1
+	case bIsNumber:
+		return 1
+	}
+	as, _ := a.(string)
+	bs, _ := b.(string)
+	return strings.Compare(as, bs)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors" // For skipping records deleted mid-iteration
+	"fmt"    // For formatted error messages
+	"os"     // For recognizing deleted records
+)
+
+// Iterator over the records of a collection, reading one file per call to Next
+// The directory is listed once up front; the collection's read lock is only held while each record
+// is read, so writers are never blocked between calls and an abandoned iterator holds nothing.
+// Records deleted after the listing are skipped, records created after it are not seen.
+type RecordIterator struct {
+	d          *Driver
+	collection string
+	files      []string // Record files still to be read
+	record     []byte   // Record read by the last call to Next
+	err        error    // Error that stopped the iteration
+	closed     bool
+}
+
+// Method to iterate over the records of a collection in ReadAll order, one file at a time
+// Memory use stays flat however big the collection is:
+//
+//	it, err := db.Iterate("users")
+//	...
+//	defer it.Close()
+//	for it.Next() {
+//		process(it.Record())
+//	}
+//	if err := it.Err(); err != nil { ... }
+func (d *Driver) Iterate(collection string) (*RecordIterator, error) {
+	// Validate that a collection name is provided
+	if collection == "" {
+		return nil, fmt.Errorf("%w - unable to read records", ErrMissingCollection)
+	}
+
+	it := &RecordIterator{d: d, collection: collection}
+
+	// Construct the directory path for the collection and check that it exists
+	dir, err := d.collectionDir(collection)
+	if err != nil {
+		if err = d.emptyIfMissing(err); err != nil {
+			return nil, err
+		}
+		return it, nil
+	}
+
+	files, err := d.recordFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if d.isRecordFile(file) {
+			it.files = append(it.files, file)
+		}
+	}
+	return it, nil
+}
+
+// Method to advance to the next record, reporting false once there are none left or on error
+func (it *RecordIterator) Next() bool {
+	it.record = nil
+	for !it.closed && it.err == nil && len(it.files) > 0 {
+		file := it.files[0]
+		it.files = it.files[1:]
+
+		b, err := it.read(file)
+		if errors.Is(err, os.ErrNotExist) {
+			continue // Deleted since the directory was listed
+		}
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.record = b
+		return true
+	}
+	return false
+}
+
+// Method to read one record while holding the collection's read lock
+func (it *RecordIterator) read(file string) ([]byte, error) {
+	mutex, err := it.d.rlockCollection(it.collection)
+	if err != nil {
+		return nil, err
+	}
+	defer mutex.RUnlock()
+	return it.d.readRecord(file)
+}
+
+// Method to get the record read by the last successful call to Next
+func (it *RecordIterator) Record() []byte {
+	return it.record
+}
+
+// Method to get the error that stopped the iteration, if any
+func (it *RecordIterator) Err() error {
+	return it.err
+}
+
+// Method to stop the iteration early; Next reports false from then on
+// It is safe to call more than once, and after the iteration finished
+func (it *RecordIterator) Close() error {
+	it.closed = true
+	it.files = nil
+	it.record = nil
+	return nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"crypto/sha256" // For hashing resource names
+	"encoding/hex"  // For turning hashes into file names
+	"errors"        // For the key error sentinels
+	"fmt"           // For formatted error messages and percent-encoding
+	"io/ioutil"     // For reading original-key sidecars
+	"os"            // For checking whether a sidecar exists
+	"path/filepath" // For walking collection directories
+	"strings"       // For building encoded names
+	"unicode/utf8"  // For rejecting invalid UTF-8 names
+)
+
+// Longest file name (without the .json extension) a resource may map to, leaving room for the
+// extension and sidecar/temp suffixes within common 255-byte file name limits
+const maxKeyLength = 200
+
+// Errors returned when a resource name can't be stored safely
+var (
+	ErrUnsafeKey    = errors.New("resource name is not a safe file name")
+	ErrKeyCollision = errors.New("resource name collides with an existing record")
+)
+
+// Function type mapping a resource name onto the file name (without extension) it is stored under
+// When the file name differs from the resource name, the driver keeps the original in a small
+// `.key` sidecar next to the record so it can be listed with Keys and checked for collisions
+type KeyEncoder func(resource string) (string, error)
+
+// Key encoder that stores names as-is and rejects the ones that aren't safe file names:
+// path separators, control characters, invalid UTF-8, "." / ".." and names longer than 200 bytes
+func SafeKeys(resource string) (string, error) {
+	switch {
+	case resource == "." || resource == "..":
+		return "", fmt.Errorf("%q: %w", resource, ErrUnsafeKey)
+	case len(resource) > maxKeyLength:
+		return "", fmt.Errorf("%q is longer than %d bytes: %w", resource[:32]+"...", maxKeyLength, ErrUnsafeKey)
+	case !utf8.ValidString(resource):
+		return "", fmt.Errorf("%q is not valid UTF-8: %w", resource, ErrUnsafeKey)
+	}
+
+	for i := 0; i < len(resource); i++ {
+		if c := resource[i]; c == '/' || c == '\\' || c < 0x20 || c == 0x7f {
+			return "", fmt.Errorf("%q: %w", resource, ErrUnsafeKey)
+		}
+	}
+	return resource, nil
+}
+
+// Key encoder that percent-encodes every byte outside a conservative safe set
+// Letters, digits, spaces and -_.~() are kept, so ordinary names like "John Doe" are unchanged;
+// a leading dot is encoded so records never become hidden files
+func PercentKeys(resource string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(resource); i++ {
+		c := resource[i]
+		if isSafeKeyByte(c) && !(i == 0 && c == '.') {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+
+	if b.Len() > maxKeyLength {
+		return "", fmt.Errorf("%q encodes to more than %d bytes: %w", resource[:32]+"...", maxKeyLength, ErrUnsafeKey)
+	}
+	return b.String(), nil
+}
+
+// Key encoder that stores every record under the hex SHA-256 of its name
+// It accepts any name, however long; use Keys to list the original names
+func HashKeys(resource string) (string, error) {
+	sum := sha256.Sum256([]byte(resource))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Helper function to report whether a byte can appear unencoded in a file name
+func isSafeKeyByte(c byte) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	case strings.IndexByte(" -_.~()", c) >= 0:
+		return true
+	}
+	return false
+}
+
+// Helper function to build the path of a record's original-key sidecar
+func keyPath(path string) string {
+	return path + ".key"
+}
+
+// Method to record the original resource name next to a record whose file name was encoded
+// It fails with ErrKeyCollision if the file already belongs to a different resource name
+func (d *Driver) writeKey(path, resource string) error {
+	if err := d.checkKey(path, resource); err != nil {
+		return err
+	}
+
+	// Nothing to remember when the name was stored as-is
+	if d.resourceName(path) == resource {
+		return nil
+	}
+
+	if err := writeAtomic(keyPath(path), []byte(resource)); err != nil {
+		return err
+	}
+	d.replicate(keyPath(path))
+	return nil
+}
+
+// Method to check that a record file belongs to the given resource name
+func (d *Driver) checkKey(path, resource string) error {
+	stored, err := ioutil.ReadFile(keyPath(path))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if string(stored) != resource {
+		return fmt.Errorf("%q and %q both map to %v: %w", resource, string(stored), filepath.Base(path), ErrKeyCollision)
+	}
+	return nil
+}
+
+// Method to turn a record file path back into its original resource name
+func (d *Driver) keyOf(path string) string {
+	if stored, err := ioutil.ReadFile(keyPath(path)); err == nil {
+		return string(stored)
+	}
+	return d.resourceName(path)
+}
+
+// Method to list the original resource names stored in a collection
+// This is the way to enumerate keys when an encoder such as HashKeys hides them in file names
+func (d *Driver) Keys(collection string) ([]string, error) {
+	// Validate that a collection name is provided
+	if collection == "" {
+		return nil, fmt.Errorf("%w - unable to list keys", ErrMissingCollection)
+	}
+
+	// Check if the collection directory exists
+	dir, err := d.collectionDir(collection)
+	if err != nil {
+		return nil, d.emptyIfMissing(err)
+	}
+
+	files, err := d.recordFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{}
+	for _, file := range files {
+		if !d.isRecordFile(file) {
+			continue // Skip temporary files
+		}
+		keys = append(keys, d.keyOf(file))
+	}
+	return keys, nil
+}
+
+// Method to list the resource names of a collection without reading any record contents
+// The names are the file names on disk with the record extension stripped, so with an encoder such as
+// HashKeys they are the encoded names; use Keys to get the original resource names back
+func (d *Driver) ListResources(collection string) ([]string, error) {
+	// Validate that a collection name is provided
+	if collection == "" {
+		return nil, fmt.Errorf("%w - unable to list resources", ErrMissingCollection)
+	}
+
+	// Check if the collection directory exists
+	dir, err := d.collectionDir(collection)
+	if err != nil {
+		return nil, d.emptyIfMissing(err)
+	}
+
+	files, err := d.recordFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, file := range files {
+		if !d.isRecordFile(file) {
+			continue // Skip temporary files
+		}
+		names = append(names, d.resourceName(file))
+	}
+	return names, nil
+}
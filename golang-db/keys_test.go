@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"  // For matching the key error sentinels
+	"sort"    // For comparing key listings regardless of order
+	"strings" // For building long and lossy names
+	"testing" // For the testing framework
+)
+
+func TestKeyEncoders(t *testing.T) {
+	long := strings.Repeat("x", maxKeyLength+1)
+	tests := []struct {
+		name     string
+		encoder  KeyEncoder
+		resource string
+		want     string
+		wantErr  error
+	}{
+		{"safe plain", SafeKeys, "John Doe", "John Doe", nil},
+		{"safe unicode", SafeKeys, "Zoë", "Zoë", nil},
+		{"safe slash", SafeKeys, "a/b", "", ErrUnsafeKey},
+		{"safe backslash", SafeKeys, `a\b`, "", ErrUnsafeKey},
+		{"safe dot dot", SafeKeys, "..", "", ErrUnsafeKey},
+		{"safe control", SafeKeys, "a\nb", "", ErrUnsafeKey},
+		{"safe invalid utf-8", SafeKeys, "a\xffb", "", ErrUnsafeKey},
+		{"safe too long", SafeKeys, long, "", ErrUnsafeKey},
+		{"percent plain", PercentKeys, "John Doe", "John Doe", nil},
+		{"percent slash", PercentKeys, "a/b", "a%2Fb", nil},
+		{"percent leading dot", PercentKeys, ".hidden", "%2Ehidden", nil},
+		{"percent percent", PercentKeys, "100%", "100%25", nil},
+		{"percent too long", PercentKeys, strings.Repeat("/", maxKeyLength), "", ErrUnsafeKey},
+		{"hash long", HashKeys, long, "", nil},
+	}
+
+	for _, tt := range tests {
+		got, err := tt.encoder(tt.resource)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: error = %v, want %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if tt.want != "" && got != tt.want {
+			t.Errorf("%s: encoded %q as %q, want %q", tt.name, tt.resource, got, tt.want)
+		}
+	}
+}
+
+func TestKeysRoundTrip(t *testing.T) {
+	resources := []string{"John Doe", "a/b", "..", "100%", "Zoë", ".hidden", strings.Repeat("long", 100)}
+	for _, encoder := range []struct {
+		name    string
+		encoder KeyEncoder
+	}{{"PercentKeys", PercentKeys}, {"HashKeys", HashKeys}} {
+		db := newTestDriver(t, &Options{KeyEncoder: encoder.encoder})
+		var stored []string
+		for _, resource := range resources {
+			if _, err := encoder.encoder(resource); err != nil {
+				continue // Too long for this encoder
+			}
+			if err := db.Insert("users", resource, User{Name: resource}); err != nil {
+				t.Fatalf("%s: Insert(%q): %v", encoder.name, resource, err)
+			}
+			stored = append(stored, resource)
+
+			var user User
+			if err := db.Read("users", resource, &user); err != nil || user.Name != resource {
+				t.Fatalf("%s: Read(%q) = %q, %v", encoder.name, resource, user.Name, err)
+			}
+		}
+
+		keys, err := db.Keys("users")
+		if err != nil {
+			t.Fatalf("%s: Keys: %v", encoder.name, err)
+		}
+		sort.Strings(keys)
+		sort.Strings(stored)
+		if strings.Join(keys, "\n") != strings.Join(stored, "\n") {
+			t.Fatalf("%s: Keys() = %q, want %q", encoder.name, keys, stored)
+		}
+
+		for _, resource := range stored {
+			if err := db.Delete("users", resource); err != nil {
+				t.Fatalf("%s: Delete(%q): %v", encoder.name, resource, err)
+			}
+		}
+		if keys, err := db.Keys("users"); err != nil || len(keys) != 0 {
+			t.Fatalf("%s: Keys() after deleting everything = %q, %v", encoder.name, keys, err)
+		}
+	}
+}
+
+func TestKeyCollision(t *testing.T) {
+	// A lossy encoder, so different names end up on the same file
+	db := newTestDriver(t, &Options{KeyEncoder: func(resource string) (string, error) {
+		return strings.ToLower(resource), nil
+	}})
+
+	if err := db.Insert("users", "Alice", User{Name: "Alice"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, resource := range []string{"ALICE", "alice"} {
+		if err := db.Insert("users", resource, User{Name: resource}); !errors.Is(err, ErrKeyCollision) {
+			t.Errorf("Insert(%q) = %v, want ErrKeyCollision", resource, err)
+		}
+		var user User
+		if err := db.Read("users", resource, &user); !errors.Is(err, ErrKeyCollision) {
+			t.Errorf("Read(%q) = %v, want ErrKeyCollision", resource, err)
+		}
+		if err := db.Delete("users", resource); !errors.Is(err, ErrKeyCollision) {
+			t.Errorf("Delete(%q) = %v, want ErrKeyCollision", resource, err)
+		}
+	}
+
+	// The record still belongs to the name that wrote it
+	var user User
+	if err := db.Read("users", "Alice", &user); err != nil || user.Name != "Alice" {
+		t.Fatalf("Read(%q) = %q, %v", "Alice", user.Name, err)
+	}
+}
+
+func TestUnsafeKeyRejected(t *testing.T) {
+	db := newTestDriver(t, nil)
+	for _, resource := range []string{"a/b", "..", "a\x00b"} {
+		err := db.Insert("users", resource, User{Name: resource})
+		if !errors.Is(err, ErrUnsafeKey) || !errors.Is(err, ErrInvalidName) {
+			t.Errorf("Insert(%q) = %v, want ErrUnsafeKey and ErrInvalidName", resource, err)
+		}
+	}
+}
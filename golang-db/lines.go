@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"         // For reading the log one line at a time
+	"bytes"         // For skipping blank lines
+	"encoding/json" // For encoding records as single lines
+	"errors"        // For inspecting wrapped errors
+	"fmt"           // For formatted error messages
+	"io"            // For detecting the end of the log
+	"os"            // For appending to and opening the log file
+	"path/filepath" // For file path operations
+)
+
+// Helper function to build the path of a line collection's log file
+// Line collections are a single <collection>.jsonl file next to the regular collection directories
+func (d *Driver) linesPath(collection string) string {
+	return filepath.Join(d.dir, collection+".jsonl")
+}
+
+// Method to append a record to a line collection, an append-only file of newline-delimited JSON
+// The record is encoded on a single line and written with one write call while holding the
+// collection lock, so concurrent appends never interleave. Records can't be read back individually;
+// use ScanLines to go through them in the order they were appended.
+// Checksums and record history don't apply to line collections.
+func (d *Driver) AppendLine(collection string, v interface{}) error {
+	if d.readOnly {
+		return ErrReadOnly
+	}
+
+	// Validate that a collection name is provided
+	if collection == "" {
+		return fmt.Errorf("%w - unable to append record", ErrMissingCollection)
+	}
+
+	// Encode the record before taking the lock, as Insert does
+	if d.canonical {
+		var err error
+		if v, err = canonicalValue(v); err != nil {
+			return err
+		}
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	path := d.linesPath(collection)
+	if err := d.checkRecordSize(path, int64(len(b))); err != nil {
+		return err
+	}
+
+	mutex, err := d.lockCollection(collection)
+	if err != nil {
+		return err
+	}
+	defer mutex.Unlock()
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(b); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	d.metrics.bytesWritten.Add(uint64(len(b)))
+
+	// The replica gets a copy of the whole log
+	d.replicate(path)
+	return nil
+}
+
+// Method to call fn with every record of a line collection, oldest first
+// The log is streamed, so memory use stays flat however many records it holds. No lock is held, so
+// records appended during the scan may or may not be seen; a last line that is still being written
+// is skipped. The scan stops at the first error fn returns.
+func (d *Driver) ScanLines(collection string, fn func(raw []byte) error) error {
+	// Validate that a collection name is provided
+	if collection == "" {
+		return fmt.Errorf("%w - unable to scan records", ErrMissingCollection)
+	}
+	if _, err := d.collectionKey(collection); err != nil {
+		return err
+	}
+
+	file, err := os.Open(d.linesPath(collection))
+	if errors.Is(err, os.ErrNotExist) {
+		return d.emptyIfMissing(fmt.Errorf("%v: %w", collection, ErrCollectionNotFound))
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return nil // Whatever is left has no newline yet, so it isn't a complete record
+		}
+		if err != nil {
+			return err
+		}
+		d.metrics.bytesRead.Add(uint64(len(line)))
+
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		if err := d.checkRecordSize(file.Name(), int64(len(line))); err != nil {
+			return err
+		}
+		if err := fn(line); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context" // For giving up on a wait
+	"sync"    // For protecting the reader count
+)
+
+// Struct representing the lock of a collection: writes hold it alone, reads share it
+// It is built on channels rather than a sync.RWMutex so that a wait can be abandoned in a select.
+// A writer waits at the turn ahead of every reader arriving after it, so a steady stream of readers
+// can't keep it out forever.
+type collectionLock struct {
+	turn    chan struct{} // Taken on the way in; a writer keeps it until it holds excl
+	excl    chan struct{} // Held by a writer, or by the readers as a group
+	mutex   sync.Mutex    // Mutex to protect `readers`
+	readers int           // Number of readers holding the lock
+}
+
+// Function to create an unlocked collection lock
+func newCollectionLock() *collectionLock {
+	return &collectionLock{
+		turn: make(chan struct{}, 1),
+		excl: make(chan struct{}, 1),
+	}
+}
+
+// Method to lock for writing, giving up with ctx.Err() once ctx is done
+func (l *collectionLock) lock(ctx context.Context) error {
+	if err := acquire(ctx, l.turn); err != nil {
+		return err
+	}
+	defer func() { <-l.turn }()
+	return acquire(ctx, l.excl)
+}
+
+// Method to release a write lock
+func (l *collectionLock) Unlock() {
+	<-l.excl
+}
+
+// Method to lock for reading, giving up with ctx.Err() once ctx is done
+func (l *collectionLock) rlock(ctx context.Context) error {
+	if err := acquire(ctx, l.turn); err != nil {
+		return err
+	}
+	defer func() { <-l.turn }()
+
+	// Join the readers already in; holding the turn, nobody else can be the first one meanwhile
+	l.mutex.Lock()
+	if l.readers > 0 {
+		l.readers++
+		l.mutex.Unlock()
+		return nil
+	}
+	l.mutex.Unlock()
+
+	// The first reader takes excl for the group, waiting for a write in progress to finish
+	if err := acquire(ctx, l.excl); err != nil {
+		return err
+	}
+	l.mutex.Lock()
+	l.readers++
+	l.mutex.Unlock()
+	return nil
+}
+
+// Method to release a read lock, the last reader out releasing it for the group
+func (l *collectionLock) RUnlock() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.readers--
+	if l.readers == 0 {
+		<-l.excl
+	}
+}
+
+// Helper function to take a one-slot channel semaphore, giving up with ctx.Err() once ctx is done
+func acquire(ctx context.Context, sem chan struct{}) error {
+	select {
+	case sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
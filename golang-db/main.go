@@ -4,12 +4,17 @@
 package main
 
 import(
+	"crypto/cipher"      // For encrypting record files at rest
+	"context"            // For cancelling calls that wait on a collection lock
 	"fmt"                // For formatted I/O operations (e.g., printing to the console)
 	"os"                 // For file operations (e.g., checking if files exist, creating directories)
 	"encoding/json"      // For JSON operations (e.g., encoding and decoding JSON)
+	"errors"             // For inspecting wrapped errors
 	"io/ioutil"          // For reading from and writing to files
 	"path/filepath"      // For file path operations (e.g., joining directory and file names)
+	"strings"            // For string manipulation (e.g., trimming file extensions)
 	"sync"               // For synchronization primitives (e.g., mutexes to handle concurrent access)
+	"time"               // For bounding how long a write waits for a collection lock
 	"github.com/jcelliott/lumber"  // A third-party logging library for structured logging
 )
 
@@ -26,16 +31,82 @@ type Logger interface{
 // Struct representing the database driver that handles the storage and retrieval of data
 type Driver struct{
 	mutex sync.Mutex               // Mutex to protect access to the `mutexes` map
-	mutexes map[string]*sync.Mutex // Map of collection names to mutexes, used to handle concurrent access to collections
+	mutexes map[string]*collectionLock // Map of collection names to locks: writes take them exclusively, reads shared
 	dir string                     // Base directory where all collections are stored
 	log Logger                     // Logger instance for logging messages
+	sharded bool                   // Whether records are stored under hash-prefixed subdirectories
+	checksums bool                 // Whether records carry a checksum sidecar that Read verifies
+	strictDecode bool              // Whether ReadAll checks that every record is valid JSON
+	order func(a, b string) bool   // Ordering applied to resource names when listing a collection
+	replica string                 // Optional mirror directory that receives a copy of every write
+	replicaMutex sync.Mutex        // Mutex to protect the `pending` map
+	pending map[string]bool        // Files (relative to dir) that failed to reach the replica
+	encodeKey KeyEncoder           // Maps resource names onto safe file names
+	lockTimeout time.Duration      // How long a read or write waits for its collection lock (0 waits forever)
+	missingAsEmpty bool            // Whether listing a missing collection returns nothing instead of an error
+	keepVersions int               // How many previous versions of each record to keep (0 keeps none)
+	readOnly bool                  // Whether every write is refused with ErrReadOnly
+	metrics driverMetrics          // Counters reported by MetricsSnapshot
+	maxRecordBytes int             // Largest record that may be written or read (0 means no limit)
+	canonical bool                 // Whether records are re-encoded with sorted keys before being stored
+	codec Codec                    // Format records are stored in
+	ext string                     // File extension of record files, taken from the codec
+	compress bool                  // Whether record files are gzipped
+	aead cipher.AEAD               // Cipher record files are encrypted with (nil stores them in plaintext)
 }
 
 // Struct representing options for configuring the database driver
 type Options struct{
 	Logger  // Embeds the Logger interface to allow custom logging
+	Sharded bool  // Store records under hash-prefixed subdirectories (e.g. ab/cd/resource.json) to keep directories small
+	Checksums bool  // Write a SHA-256 checksum sidecar for every record and verify it on Read
+	VerifyChecksums bool  // Deprecated: use Checksums, which this turns on as well
+	StrictDecode bool  // Make ReadAll fail with a DecodeError naming the first record that isn't valid JSON
+	Order func(a, b string) bool  // Order ReadAll results by resource name (e.g. NaturalLess); defaults to lexical order
+	Replica string  // Mirror every write to this directory (best effort) and fall back to it on Read
+	KeyEncoder KeyEncoder  // Maps resource names onto file names (SafeKeys, PercentKeys or HashKeys); defaults to SafeKeys
+	LockTimeout time.Duration  // Fail reads and writes with ErrLockTimeout when a collection stays locked this long; 0 waits forever
+	MissingAsEmpty bool  // Make ReadAll, Count, Keys, ListResources, Project and the other listing methods treat a missing collection as empty instead of returning ErrCollectionNotFound
+	KeepVersions int  // Keep up to this many previous versions of each record under <collection>/.versions (see ReadVersion)
+	ReadOnly bool  // Refuse every write with ErrReadOnly and never create directories, for safely reading a database another process owns
+	MaxRecordBytes int  // Refuse to write or read a record file larger than this many bytes with ErrRecordTooLarge; 0 means no limit
+	Canonical bool  // Store every record with its object keys sorted, so writing the same data twice gives byte-identical files
+	Codec Codec  // Format records are stored in, and the file extension they get; defaults to JSONCodec
+	Compress bool  // Gzip every record file, stored with an extra .gz extension (e.g. resource.json.gz); records written without it aren't seen
+	EncryptionKey []byte  // Encrypt every record file with AES-256-GCM under this 32-byte key; empty stores records in plaintext
 }
 
+// Error returned when the requested collection directory doesn't exist
+// A missing record inside an existing collection is reported with ErrRecordNotFound instead
+var ErrCollectionNotFound = errors.New("collection not found")
+
+// Errors returned when a call is missing its collection or resource name, or the record doesn't exist
+// The original messages (e.g. "Missing Collection - no place to save record") are kept, with these
+// wrapped in them so callers can use errors.Is.
+var (
+	ErrMissingCollection = errors.New("Missing Collection")
+	ErrMissingResource   = errors.New("Missing Resource")
+	ErrRecordNotFound    = errors.New("record not found")
+)
+
+// Same error as ErrRecordNotFound, so errors.Is matches either name
+//
+// Deprecated: use ErrRecordNotFound.
+var ErrNotFound = ErrRecordNotFound
+
+// Error returned by reads and writes that gave up waiting for a collection lock (see Options.LockTimeout)
+var ErrLockTimeout = errors.New("timed out waiting for collection lock")
+
+// Error returned by every write when the driver was opened with Options.ReadOnly
+var ErrReadOnly = errors.New("database is read-only")
+
+// Error returned when a record is larger than Options.MaxRecordBytes, on write or on read
+var ErrRecordTooLarge = errors.New("record too large")
+
+// Error returned when a collection or resource name could reach outside the database directory
+// Resource names rejected by the key encoder match both this and ErrUnsafeKey
+var ErrInvalidName = errors.New("invalid name")
+
 // Function to create a new database driver instance
 // It initializes the base directory and logging options, and ensures that the directory exists
 func New(dir string, options *Options) (*Driver, error){
@@ -52,18 +123,69 @@ func New(dir string, options *Options) (*Driver, error){
 	if opts.Logger == nil {
 		opts.Logger = lumber.NewConsoleLogger(lumber.INFO)
 	}
+
+	// If no key encoder is provided, reject resource names that aren't safe file names
+	if opts.KeyEncoder == nil {
+		opts.KeyEncoder = SafeKeys
+	}
+
+	// If no codec is provided, store records as JSON
+	if opts.Codec == nil {
+		opts.Codec = JSONCodec
+	}
 	
 	// Create a new Driver instance with the given directory and logger
 	driver := Driver{
 		dir: dir,
-		mutexes: make(map[string]*sync.Mutex),  // Initialize the map for mutexes
+		mutexes: make(map[string]*collectionLock),  // Initialize the map for mutexes
 		log: opts.Logger,
+		sharded: opts.Sharded,
+		checksums: opts.Checksums || opts.VerifyChecksums,
+		strictDecode: opts.StrictDecode,
+		order: opts.Order,
+		pending: make(map[string]bool),
+		encodeKey: opts.KeyEncoder,
+		lockTimeout: opts.LockTimeout,
+		missingAsEmpty: opts.MissingAsEmpty,
+		keepVersions: opts.KeepVersions,
+		readOnly: opts.ReadOnly,
+		maxRecordBytes: opts.MaxRecordBytes,
+		canonical: opts.Canonical,
+		codec: opts.Codec,
+		ext: opts.Codec.Extension(),
+		compress: opts.Compress,
+	}
+
+	// Compressed records get their own extension, so they are never mistaken for plain ones
+	if opts.Compress {
+		driver.ext += compressedExt
+	}
+
+	// Set up encryption at rest, if a key is provided
+	if len(opts.EncryptionKey) > 0 {
+		aead, err := newRecordCipher(opts.EncryptionKey)
+		if err != nil {
+			return nil, err
+		}
+		driver.aead = aead
+	}
+
+	// Prepare the replica directory, if one is configured (a read-only driver only reads from it)
+	if opts.Replica != "" {
+		driver.replica = filepath.Clean(opts.Replica)
+		if !opts.ReadOnly {
+			if err := os.MkdirAll(driver.replica, 0755); err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	// Check if the directory already exists
 	if _, err := os.Stat(dir); err == nil {
 		opts.Logger.Debug("Using '%s' (database already exists)\n", dir)
 		return &driver, nil
+	} else if opts.ReadOnly {
+		return nil, err  // A read-only driver never creates the database
 	}
 	
 	// If the directory does not exist, create it and log the action
@@ -73,146 +195,493 @@ func New(dir string, options *Options) (*Driver, error){
 
 // Method to insert a record into the database
 // It saves the data as a JSON file in the specified collection and resource name
+// Collections can be nested with path-style names (e.g. "users/123/orders"); the directories are created as needed
 func (d *Driver) Insert(collection, resource string, v interface{}) error {
+	return d.InsertContext(context.Background(), collection, resource, v)
+}
+
+// Method to insert a record like Insert, giving up with ctx.Err() once ctx is done
+// The context is checked before waiting for the collection lock, while waiting, and again before
+// the file is written; once the write has started it runs to completion.
+func (d *Driver) InsertContext(ctx context.Context, collection, resource string, v interface{}) (err error) {
+	defer d.metrics.observe(&d.metrics.inserts, &err)  // Count the call for MetricsSnapshot
+
+	_, err = d.insert(ctx, collection, resource, v, true)
+	return err
+}
+
+// Method to insert a record, or overwrite it if it already exists, reporting which one happened
+// Insert already overwrites; this also tells the caller whether the record was newly created.
+// A temporary file left behind by an interrupted write doesn't count as a record and is replaced.
+func (d *Driver) Upsert(collection, resource string, v interface{}) (inserted bool, err error) {
+	defer d.metrics.observe(&d.metrics.inserts, &err)  // Count the call for MetricsSnapshot
+
+	existed, err := d.insert(context.Background(), collection, resource, v, true)
+	return err == nil && !existed, err
+}
+
+// Method doing the work of Insert and Upsert, reporting whether the record existed before
+// With overwrite unset an existing record is left alone and nothing is written.
+func (d *Driver) insert(ctx context.Context, collection, resource string, v interface{}, overwrite bool) (existed bool, err error) {
+	// Refuse to write when the driver was opened read-only
+	if d.readOnly {
+		return false, ErrReadOnly
+	}
+
 	// Validate that a collection name is provided
 	if collection == "" {
-		return fmt.Errorf("Missing Collection - no place to save record")
+		return false, fmt.Errorf("%w - no place to save record", ErrMissingCollection)
 	}
 	
 	// Validate that a resource name is provided
 	if resource == "" {
-		return fmt.Errorf("Missing Resource - unable to save record (no name)")
+		return false, fmt.Errorf("%w - unable to save record (no name)", ErrMissingResource)
 	}
 	
-	// Obtain or create a mutex for the collection to ensure thread-safe access
-	mutex := d.getOrCreateMutex(collection)
-	mutex.Lock()              // Lock the mutex to prevent concurrent writes
-	defer mutex.Unlock()      // Ensure the mutex is unlocked after the function finishes
-
-	// Construct the directory path for the collection and the final file path for the resource
-	dir := filepath.Join(d.dir, collection)
-	finalPath := filepath.Join(dir, resource + ".json")
-	tempPath := finalPath + ".tmp"  // Use a temporary file path to ensure safe file writing
-
-	// Ensure the collection directory exists, creating it if necessary
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return err
+	// Construct the final file path for the resource (inside its shard directory when sharding is enabled)
+	finalPath, err := d.resolvePath(collection, resource)
+	if err != nil {
+		return false, err
 	}
 
 	// Convert the data (v) to a pretty-printed JSON format
-	b, err := json.MarshalIndent(v, "", "\t")
+	// This is done before taking the lock, so concurrent writers to a collection only serialize on disk I/O
+	b, err := d.marshalRecord(v)
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	// Append a newline character to the JSON data for readability
-	b = append(b, byte('\n'))
+	// Refuse records over the size limit before anything is written
+	if err := d.checkRecordSize(finalPath, int64(len(b))); err != nil {
+		return false, err
+	}
 	
-	// Write the JSON data to a temporary file
-	if err := ioutil.WriteFile(tempPath, b, 0644); err != nil {
-		return err
+	// Obtain or create a mutex for the collection to ensure thread-safe access
+	mutex, err := d.lockCollectionContext(ctx, collection)  // Lock the mutex to prevent concurrent writes
+	if err != nil {
+		return false, err
 	}
+	defer mutex.Unlock()      // Ensure the mutex is unlocked after the function finishes
 
-	// Rename the temporary file to the final file path, making the write operation atomic
-	return os.Rename(tempPath, finalPath)
+	// Don't start writing for a caller that has already given up
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	// Note whether the record is already there; a temp file left by a crashed write doesn't count
+	if _, err := os.Stat(finalPath); err == nil {
+		existed = true
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+	if existed && !overwrite {
+		return true, nil
+	}
+
+	// Ensure the collection directory exists, creating it if necessary
+	if err := os.MkdirAll(filepath.Dir(finalPath), 0755); err != nil {
+		return false, err
+	}
+
+	// Remember the original name when the encoder changed it, refusing to overwrite a different key
+	if err := d.writeKey(finalPath, resource); err != nil {
+		return false, err
+	}
+	
+	// Write the JSON data to the final path via a temporary file
+	return existed, d.writeRecord(finalPath, b)
 }
 
 // Method to read a single record from the database
 // It reads the JSON file for the specified collection and resource, and unmarshals it into the provided struct
 func (d *Driver) Read(collection, resource string, v interface{}) error {
+	return d.ReadContext(context.Background(), collection, resource, v)
+}
+
+// Method to read a record like Read, giving up with ctx.Err() once ctx is done
+// The context is checked before waiting for the collection lock, while waiting, and again before
+// the file is read.
+func (d *Driver) ReadContext(ctx context.Context, collection, resource string, v interface{}) (err error) {
+	defer d.metrics.observe(&d.metrics.reads, &err)  // Count the call for MetricsSnapshot
+
 	// Validate that a collection name is provided
 	if collection == "" {
-		return fmt.Errorf("Missing Collection - unable to read records")
+		return fmt.Errorf("%w - unable to read records", ErrMissingCollection)
 	}
 	
 	// Validate that a resource name is provided
 	if resource == "" {
-		return fmt.Errorf("Missing Resource - unable to read record (no name)")
+		return fmt.Errorf("%w - unable to read record (no name)", ErrMissingResource)
 	}
 	
 	// Construct the file path for the resource's JSON file
-	record := filepath.Join(d.dir, collection, resource + ".json")
-
-	// Check if the file exists
-	if _, err := stat(record); err != nil {
+	record, err := d.resolvePath(collection, resource)
+	if err != nil {
 		return err
 	}
 
-	// Read the JSON data from the file
-	b, err := ioutil.ReadFile(record)
+	// Hold the collection's read lock, so a write in progress finishes before the record is read
+	mutex, err := d.rlockCollectionContext(ctx, collection)
 	if err != nil {
 		return err
 	}
+	defer mutex.RUnlock()
+
+	// Don't read for a caller that has already given up
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
-	// Unmarshal the JSON data into the provided struct (v)
-	return json.Unmarshal(b, &v)
+	// Make sure the file really belongs to this resource and not to one that encodes the same way
+	if err := d.checkKey(record, resource); err != nil {
+		return err
+	}
+
+	// Read the JSON data from the file, verifying its checksum if enabled
+	// (a missing or corrupt primary copy falls back to the replica, if configured)
+	b, err := d.readRecord(record)
+	if err != nil {
+		return d.readError(collection, resource, err)
+	}
+
+	// Unmarshal the data into the provided struct (v) with the configured codec
+	// A failure is wrapped with the record's name and byte offset so the bad file can be found
+	if err := d.codec.Unmarshal(b, &v); err != nil {
+		return decodeError(collection, resource, err)
+	}
+	return nil
+}
+
+// Method to classify an error from reading a record, so callers can tell "not found" from a real failure
+// A missing file matches ErrRecordNotFound (and ErrCollectionNotFound too when the whole collection
+// is missing); anything else, such as a permission or checksum error, is wrapped with the record's name.
+func (d *Driver) readError(collection, resource string, err error) error {
+	if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("unable to read %v/%v: %w", collection, resource, err)
+	}
+
+	// Tell a collection that was never created apart from a record that doesn't exist
+	if _, cerr := d.collectionDir(collection); errors.Is(cerr, ErrCollectionNotFound) {
+		return fmt.Errorf("%w: %w", cerr, ErrRecordNotFound)
+	}
+	return fmt.Errorf("%v/%v: %w", collection, resource, ErrRecordNotFound)
+}
+
+// Method to check whether a record exists without reading it
+// A missing record or collection is reported as false with no error; only genuine I/O problems
+// (e.g. permission denied) are returned as errors
+func (d *Driver) Exists(collection, resource string) (bool, error) {
+	// Validate that a collection name is provided
+	if collection == "" {
+		return false, fmt.Errorf("%w - unable to check record", ErrMissingCollection)
+	}
+
+	// Validate that a resource name is provided
+	if resource == "" {
+		return false, fmt.Errorf("%w - unable to check record (no name)", ErrMissingResource)
+	}
+
+	record, err := d.resolvePath(collection, resource)
+	if err != nil {
+		return false, err
+	}
+
+	// Check that the file exists and is a record, not a directory
+	fi, err := d.stat(record)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !fi.Mode().IsRegular() {
+		return false, nil
+	}
+
+	// A file that belongs to a different resource name with the same encoding doesn't count
+	if err := d.checkKey(record, resource); errors.Is(err, ErrKeyCollision) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
 // Method to read all records from a collection
 // It reads all JSON files in the collection directory and returns their contents as a slice of strings
-func (d *Driver) ReadAll(collection string) ([]string, error){
+func (d *Driver) ReadAll(collection string) (records []string, err error){
+	defer d.metrics.observe(&d.metrics.readAlls, &err)  // Count the call for MetricsSnapshot
+
 	// Validate that a collection name is provided
 	if collection == "" {
-		return nil, fmt.Errorf("Missing Collection - unable to read records")
+		return nil, fmt.Errorf("%w - unable to read records", ErrMissingCollection)
 	}
-	
-	// Construct the directory path for the collection
-	dir := filepath.Join(d.dir, collection)
 
-	// Check if the directory exists
-	if _, err := stat(dir); err != nil {
+	// Hold the collection's read lock, so no record is read halfway through a write
+	mutex, err := d.rlockCollection(collection)
+	if err != nil {
 		return nil, err
 	}
+	defer mutex.RUnlock()
+	
+	// Construct the directory path for the collection and check that it exists
+	dir, err := d.collectionDir(collection)
+	if err != nil {
+		return nil, d.emptyIfMissing(err)
+	}
 
-	// Read the list of files in the collection directory
-	files, _ := ioutil.ReadDir(dir)
+	// Read the list of record files in the collection directory (walking the shard tree if sharded)
+	files, err := d.recordFiles(dir)
+	if err != nil {
+		return nil, err
+	}
 
-	// Initialize a slice to hold the contents of all records
-	var records []string
+	// The records slice (a named result) holds the contents of all records
+	var corrupted []string
 	for _, file := range files {
-		if file.IsDir() {
-			continue  // Skip directories, as we are only interested in files
+		// Skip temporary files, which may hold a half-written record from a crashed write
+		if !d.isRecordFile(file) {
+			continue
 		}
-		
+
 		// Read the contents of each file and append it to the records slice
-		b, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		b, err := d.readRecord(file)
+		if errors.Is(err, ErrCorrupted) {
+			// Keep going so one bad record doesn't hide all the good ones
+			corrupted = append(corrupted, d.keyOf(file))
+			continue
+		}
 		if err != nil {
 			return nil, err
 		}
+
+		// In strict mode, point at the malformed record instead of leaving callers to guess
+		if d.strictDecode {
+			var raw json.RawMessage
+			if err := json.Unmarshal(b, &raw); err != nil {
+				return nil, decodeError(collection, d.keyOf(file), err)
+			}
+		}
 		records = append(records, string(b))
 	}
+
+	// Report the records that failed verification alongside the ones that passed
+	if len(corrupted) > 0 {
+		return records, &CorruptedRecordsError{Collection: collection, Resources: corrupted}
+	}
 	return records, nil
 }
 
+// Method to count the records in a collection without reading them
+// Only record files are counted: directories, sidecars and leftover temporary files are skipped.
+// An existing but empty collection counts 0; a missing one returns ErrCollectionNotFound.
+func (d *Driver) Count(collection string) (int, error) {
+	// Validate that a collection name is provided
+	if collection == "" {
+		return 0, fmt.Errorf("%w - unable to count records", ErrMissingCollection)
+	}
+
+	// Hold the collection's read lock, so a write in progress isn't counted halfway through
+	mutex, err := d.rlockCollection(collection)
+	if err != nil {
+		return 0, err
+	}
+	defer mutex.RUnlock()
+
+	// Construct the directory path for the collection and check that it exists
+	dir, err := d.collectionDir(collection)
+	if err != nil {
+		return 0, d.emptyIfMissing(err)
+	}
+
+	// List the record files (walking the shard tree if sharded), without sorting them
+	files, err := d.listFiles(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, file := range files {
+		if d.isRecordFile(file) {
+			count++
+		}
+	}
+	return count, nil
+}
+
 // Method to delete a record from the database
 // It deletes the specified file or directory from the collection
+// A symlinked collection or record only has its link removed, the files it points to are left alone
 func (d *Driver) Delete(collection, resource string) error {
+	return d.DeleteContext(context.Background(), collection, resource)
+}
+
+// Method to delete a record like Delete, giving up with ctx.Err() once ctx is done
+// The context is checked before waiting for the collection lock, while waiting, and again before
+// anything is removed.
+func (d *Driver) DeleteContext(ctx context.Context, collection, resource string) (err error) {
+	defer d.metrics.observe(&d.metrics.deletes, &err)  // Count the call for MetricsSnapshot
+
+	// Refuse to write when the driver was opened read-only
+	if d.readOnly {
+		return ErrReadOnly
+	}
+
 	// Construct the path for the resource within the collection
 	path := filepath.Join(collection, resource)
+
+	// Construct the full path for the resource, validating the names before anything is locked
+	// A named resource may live in a shard directory, so resolve it the same way Insert does
+	dir := filepath.Join(d.dir, path)
+	if resource != "" {
+		record, err := d.resolvePath(collection, resource)
+		if err != nil {
+			return err
+		}
+		dir = strings.TrimSuffix(record, d.ext)
+	}
 	
 	// Obtain or create a mutex for the collection to ensure thread-safe access
-	mutex := d.getOrCreateMutex(collection)
-	mutex.Lock()              // Lock the mutex to prevent concurrent deletions
+	mutex, err := d.lockCollectionContext(ctx, collection)  // Lock the mutex to prevent concurrent deletions
+	if err != nil {
+		return err
+	}
 	defer mutex.Unlock()      // Ensure the mutex is unlocked after the function finishes
-	
-	// Construct the full path for the resource
-	dir := filepath.Join(d.dir, path)
+
+	// Don't delete for a caller that has already given up
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	
 	// Determine whether the resource is a file or directory, and delete it accordingly
-	switch fi, err := stat(dir); {
+	switch fi, err := d.stat(dir); {
 		case fi == nil, err != nil:  // If the file or directory does not exist, return an error
-			return fmt.Errorf("unable to find file or directory named %v \n", path)
+			return fmt.Errorf("unable to find file or directory named %v: %w", path, ErrRecordNotFound)
 		case fi.Mode().IsDir():      // If the path is a directory, delete the entire directory
-			return os.RemoveAll(dir)
-		case fi.Mode().IsRegular():  // If the path is a regular file, delete the file with the ".json" extension
-			return os.RemoveAll(dir + ".json")
+			if err := os.RemoveAll(dir); err != nil {
+				return err
+			}
+			d.replicate(dir)  // Propagate the deletion to the replica
+		case fi.Mode().IsRegular():  // If the path is a regular file, delete the record file
+			// Make sure the file really belongs to this resource and not to one that encodes the same way
+			if err := d.checkKey(dir + d.ext, resource); err != nil {
+				return err
+			}
+
+			// Remove the record along with its sidecars (checksum, original key), if any
+			files := append([]string{dir + d.ext}, sidecars(dir + d.ext)...)
+			for _, file := range files {
+				if err := os.RemoveAll(file); err != nil {
+					return err
+				}
+			}
+			d.replicate(files...)  // Propagate the deletion to the replica
+			return d.updateIndexes(dir + d.ext, resource, nil)  // Drop the record from the collection's indexes
 	}
 	return nil
 }
 
+// Method to drop a whole collection, removing its directory and everything in it
+// Dropping a collection that doesn't exist is not an error, so it is safe to call twice.
+// The collection's mutex is forgotten afterwards so short-lived collections don't leak memory;
+// a call already waiting on the old mutex moves over to the new one once the drop is done.
+func (d *Driver) DropCollection(collection string) error {
+	// Refuse to write when the driver was opened read-only
+	if d.readOnly {
+		return ErrReadOnly
+	}
+
+	// Validate that a collection name is provided
+	if collection == "" {
+		return fmt.Errorf("%w - unable to drop collection", ErrMissingCollection)
+	}
+
+	key, err := d.collectionKey(collection)
+	if err != nil {
+		return err
+	}
+	mutex, err := d.lockCollection(collection)
+	if err != nil {
+		return err
+	}
+	defer mutex.Unlock()
+
+	// Remove the directory, or only the link for a symlinked collection
+	dir := filepath.Join(d.dir, collection)
+	if _, err := os.Lstat(dir); err == nil {
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+		d.replicate(dir)  // Propagate the deletion to the replica
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	d.mutex.Lock()
+	delete(d.mutexes, key)
+	d.mutex.Unlock()
+	return nil
+}
+
+// Method to resolve a collection's directory, checking that it exists
+// Returns ErrCollectionNotFound (wrapped with the collection name) if it doesn't
+func (d *Driver) collectionDir(collection string) (string, error) {
+	if _, err := d.collectionKey(collection); err != nil {
+		return "", err
+	}
+	dir := filepath.Join(d.dir, collection)
+	fi, err := os.Stat(dir)
+	if errors.Is(err, os.ErrNotExist) || (err == nil && !fi.IsDir()) {
+		return "", fmt.Errorf("%v: %w", collection, ErrCollectionNotFound)
+	}
+	if err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// Method to turn a collection name into the path of its directory relative to the database
+// Collections can be nested with path-style names (e.g. "users/123/orders"); every spelling of the
+// same directory ("users//123/orders/") gives the same key, so they share one lock. Names with a
+// ".." component or a NUL byte, or that point at the database directory itself, are rejected with
+// ErrInvalidName before anything touches the filesystem.
+func (d *Driver) collectionKey(collection string) (string, error) {
+	if strings.ContainsRune(collection, 0) {
+		return "", fmt.Errorf("collection %q: %w", collection, ErrInvalidName)
+	}
+	for _, part := range strings.FieldsFunc(collection, isPathSeparator) {
+		if part == ".." {
+			return "", fmt.Errorf("collection %q: %w", collection, ErrInvalidName)
+		}
+	}
+
+	key, err := filepath.Rel(d.dir, filepath.Join(d.dir, collection))
+	if err != nil {
+		return "", err
+	}
+	if key == "." {
+		return "", fmt.Errorf("collection %q: %w", collection, ErrInvalidName)
+	}
+	return key, nil
+}
+
+// Helper function to report whether a character separates path components, on any platform
+func isPathSeparator(c rune) bool {
+	return c == '/' || c == '\\'
+}
+
+// Helper function for methods that list a collection, honouring Options.MissingAsEmpty
+// It swallows ErrCollectionNotFound when the option is set and returns any other error unchanged
+func (d *Driver) emptyIfMissing(err error) error {
+	if d.missingAsEmpty && errors.Is(err, ErrCollectionNotFound) {
+		return nil
+	}
+	return err
+}
+
 // Helper function to get or create a mutex for a given collection
 // Ensures that each collection has its own mutex to handle concurrent access
-func (d *Driver) getOrCreateMutex(collection string) *sync.Mutex {
+func (d *Driver) getOrCreateMutex(collection string) *collectionLock {
 	d.mutex.Lock()              // Lock the main mutex to protect the `mutexes` map
 	defer d.mutex.Unlock()      // Ensure the main mutex is unlocked after the function finishes
 	
@@ -220,17 +689,342 @@ func (d *Driver) getOrCreateMutex(collection string) *sync.Mutex {
 	m, ok := d.mutexes[collection]
 	if !ok {
 		// If not, create a new mutex and store it in the map
-		m = &sync.Mutex{}
+		m = newCollectionLock()
 		d.mutexes[collection] = m
 	}
 	return m
 }
 
+// Helper function to report whether a lock is still the one kept for a collection
+// DropCollection forgets the lock of the collection it drops, so a caller queued on it only gets it
+// once it has been replaced, and must not go ahead alongside callers holding the new one.
+func (d *Driver) isCurrentMutex(collection string, m *collectionLock) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.mutexes[collection] == m
+}
+
+// Helper function to lock a collection for writing, honouring the configured lock timeout
+// Without a timeout this waits as long as it takes. With one, it gives up once the timeout passes,
+// returning ErrLockTimeout (wrapped with the collection name) with nothing locked.
+func (d *Driver) lockCollection(collection string) (*collectionLock, error) {
+	return d.lockCollectionContext(context.Background(), collection)
+}
+
+// Helper function to lock a collection for writing, also giving up with ctx.Err() once ctx is done
+func (d *Driver) lockCollectionContext(ctx context.Context, collection string) (*collectionLock, error) {
+	key, err := d.collectionKey(collection)
+	if err != nil {
+		return nil, err
+	}
+	var mutex *collectionLock
+	err = d.waitForLock(ctx, collection, func(ctx context.Context) error {
+		for {
+			mutex = d.getOrCreateMutex(key)
+			if err := mutex.lock(ctx); err != nil {
+				return err
+			}
+			if d.isCurrentMutex(key, mutex) {
+				return nil
+			}
+			mutex.Unlock()  // Dropped while we waited; take the collection's new lock instead
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mutex, nil
+}
+
+// Helper function to lock a collection for reading, honouring the configured lock timeout
+// Any number of readers may hold it at once; they only wait for a write in progress to finish.
+// The caller must release it with RUnlock.
+func (d *Driver) rlockCollection(collection string) (*collectionLock, error) {
+	return d.rlockCollectionContext(context.Background(), collection)
+}
+
+// Helper function to lock a collection for reading, also giving up with ctx.Err() once ctx is done
+func (d *Driver) rlockCollectionContext(ctx context.Context, collection string) (*collectionLock, error) {
+	key, err := d.collectionKey(collection)
+	if err != nil {
+		return nil, err
+	}
+	var mutex *collectionLock
+	err = d.waitForLock(ctx, collection, func(ctx context.Context) error {
+		for {
+			mutex = d.getOrCreateMutex(key)
+			if err := mutex.rlock(ctx); err != nil {
+				return err
+			}
+			if d.isCurrentMutex(key, mutex) {
+				return nil
+			}
+			mutex.RUnlock()  // Dropped while we waited; take the collection's new lock instead
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mutex, nil
+}
+
+// Helper function to take a lock, giving up with ErrLockTimeout once the configured timeout passes
+// or with ctx.Err() once ctx is done. The timeout is folded into the context the lock waits on, and
+// told apart from ctx ending by ctx itself still being live.
+func (d *Driver) waitForLock(ctx context.Context, collection string, lock func(context.Context) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if d.lockTimeout <= 0 {
+		return lock(ctx)
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, d.lockTimeout)
+	defer cancel()
+	if err := lock(timeoutCtx); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%v: %w", collection, ErrLockTimeout)
+	}
+	return nil
+}
+
+// Helper function to write a file atomically
+// The data is written to a temporary file first and then renamed over the final path,
+// so readers either see the old contents or the new contents, never a partial write
+func writeAtomic(finalPath string, b []byte) error {
+	tempPath := finalPath + ".tmp"  // Use a temporary file path to ensure safe file writing
+
+	// Write the data to the temporary file
+	if err := ioutil.WriteFile(tempPath, b, 0644); err != nil {
+		return err
+	}
+
+	// Rename the temporary file to the final file path, making the write operation atomic
+	return os.Rename(tempPath, finalPath)
+}
+
+// Method to encode a record the way it is stored on disk
+func (d *Driver) marshalRecord(v interface{}) ([]byte, error) {
+	// Put the record in canonical form first, if enabled
+	if d.canonical {
+		var err error
+		if v, err = canonicalValue(v); err != nil {
+			return nil, err
+		}
+	}
+
+	// Encode the data (v) with the configured codec
+	return d.codec.Marshal(v)
+}
+
+// Helper function to turn a record into plain maps, slices and numbers
+// encoding/json writes struct fields in declaration order but map keys sorted; after a round trip
+// through this generic form every object's keys come out sorted, whatever Go type the record was.
+// Numbers are kept exactly as they were encoded.
+func canonicalValue(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return decodeValue(b)
+}
+
+// Method to write a record file along with any per-record metadata enabled in the options
+func (d *Driver) writeRecord(path string, b []byte) error {
+	stored, err := d.stageRecord(path, b)
+	if err != nil {
+		return err
+	}
+	return d.commitRecord(path, b, stored)
+}
+
+// Method doing the first half of writeRecord: writing the record's temporary file
+// It returns the bytes that were stored; nothing a reader can see has changed yet
+func (d *Driver) stageRecord(path string, b []byte) ([]byte, error) {
+	// Refuse records over the size limit before touching the disk
+	if err := d.checkRecordSize(path, int64(len(b))); err != nil {
+		return nil, err
+	}
+
+	// Compress and encrypt the record, if enabled; the result goes through the same atomic write
+	stored, err := d.encodeStored(b)
+	if err != nil {
+		return nil, err
+	}
+	// Stage the checksum before the record, so the sidecar can never describe an older write
+	if d.checksums {
+		if err := stageChecksum(path, stored); err != nil {
+			return nil, err
+		}
+	}
+	if err := ioutil.WriteFile(path + ".tmp", stored, 0644); err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
+
+// Method doing the second half of writeRecord: renaming the staged file into place
+// b holds the record's encoded bytes and stored what stageRecord wrote
+func (d *Driver) commitRecord(path string, b, stored []byte) error {
+	// Keep the contents being replaced, if history is enabled
+	if err := d.saveVersion(path); err != nil {
+		return err
+	}
+
+	// Rename the temporary file to the final file path, making the write operation atomic
+	if err := os.Rename(path + ".tmp", path); err != nil {
+		return err
+	}
+	d.metrics.bytesWritten.Add(uint64(len(stored)))
+
+	// Keep the collection's sorted indexes in step with the record
+	if err := d.updateIndexes(path, d.keyOf(path), b); err != nil {
+		return err
+	}
+
+	// Move the checksum of the file into place next to the record so Read can detect corruption
+	if d.checksums {
+		if err := commitChecksum(path); err != nil {
+			return err
+		}
+		d.replicate(path, checksumPath(path))
+		return nil
+	}
+	d.replicate(path)
+	return nil
+}
+
+// Helper function to enforce Options.MaxRecordBytes on a record of the given size
+func (d *Driver) checkRecordSize(path string, size int64) error {
+	if d.maxRecordBytes > 0 && size > int64(d.maxRecordBytes) {
+		return fmt.Errorf("%v is %d bytes, over the %d byte limit: %w", filepath.Base(path), size, d.maxRecordBytes, ErrRecordTooLarge)
+	}
+	return nil
+}
+
+// Helper function to enforce Options.MaxRecordBytes on a record file before it is loaded
+// The limit is on the record itself, so the file may be larger by what compression and encryption add to it
+func (d *Driver) checkStoredSize(path string, size int64) error {
+	if d.maxRecordBytes > 0 && size > int64(d.maxRecordBytes)+d.storedOverhead() {
+		return fmt.Errorf("%v is %d bytes on disk, over the %d byte limit: %w", filepath.Base(path), size, d.maxRecordBytes, ErrRecordTooLarge)
+	}
+	return nil
+}
+
+// Helper function to get how many bytes storing a record can add on top of the record itself
+func (d *Driver) storedOverhead() int64 {
+	var overhead int64
+	if d.compress {
+		// Gzip grows data it can't compress by a few bytes per block, plus its header and trailer
+		overhead += int64(d.maxRecordBytes)/1000 + 64
+	}
+	if d.aead != nil {
+		overhead += int64(d.aead.NonceSize() + d.aead.Overhead())
+	}
+	return overhead
+}
+
+// Method to read a record file, verifying its checksum when checksums are enabled
+// If the primary copy is missing or corrupt and a replica is configured, the replica copy is used
+func (d *Driver) readRecord(path string) ([]byte, error) {
+	// Refuse to load a file that has grown past the size limit
+	if d.maxRecordBytes > 0 {
+		if fi, err := os.Stat(path); err == nil {
+			if err := d.checkStoredSize(path, fi.Size()); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	b, err := readVerified(path, d.checksums)
+	if err != nil && d.replica != "" && (errors.Is(err, os.ErrNotExist) || errors.Is(err, ErrCorrupted)) {
+		if rb, rerr := readVerified(d.replicaPath(path), d.checksums); rerr == nil {
+			d.log.Warn("Serving '%s' from replica: %v", path, err)
+			b, err = rb, nil
+		}
+	}
+	d.metrics.bytesRead.Add(uint64(len(b)))
+	if err != nil {
+		return nil, err
+	}
+
+	// Decrypt and decompress the record, if enabled, then hold it to the same limit as on write
+	if b, err = d.decodeStored(path, b); err != nil {
+		return nil, err
+	}
+	if err := d.checkRecordSize(path, int64(len(b))); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Method to turn a record's encoded bytes into the bytes stored in its file
+// The record is compressed first, then encrypted, according to the options
+func (d *Driver) encodeStored(b []byte) ([]byte, error) {
+	b, err := d.compressRecord(b)
+	if err != nil {
+		return nil, err
+	}
+	return d.encryptRecord(b)
+}
+
+// Method to turn the bytes stored in a record file back into the record's encoded bytes
+func (d *Driver) decodeStored(path string, b []byte) ([]byte, error) {
+	b, err := d.decryptRecord(path, b)
+	if err != nil {
+		return nil, err
+	}
+	return d.decompressRecord(path, b)
+}
+
+// Helper function to read a file, optionally verifying it against its checksum sidecar
+func readVerified(path string, checksums bool) ([]byte, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if checksums {
+		if err := verifyChecksum(path, b); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// Suffixes of the metadata files that can sit next to a record file
+// (.sum.tmp is a checksum staged by a write that crashed before renaming it into place)
+var sidecarSuffixes = []string{".sum", ".sum.tmp", ".key"}
+
+// Helper function to list the sidecar paths belonging to a record file
+func sidecars(path string) []string {
+	var paths []string
+	for _, suffix := range sidecarSuffixes {
+		paths = append(paths, path + suffix)
+	}
+	return paths
+}
+
+// Helper function to report whether a file holds metadata rather than a record
+func isMetadataFile(name string) bool {
+	if name == sequenceFileName {
+		return true  // The InsertAuto counter
+	}
+	for _, suffix := range sidecarSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
 // Helper function to check if a file exists with the given path
-// Also checks for the existence of a file with a ".json" extension if the original path does not exist
-func stat(path string) (fi os.FileInfo, err error) {
+// Also checks for the existence of a file with the record extension if the original path does not exist
+func (d *Driver) stat(path string) (fi os.FileInfo, err error) {
 	if fi, err = os.Stat(path); os.IsNotExist(err) {
-		fi, err = os.Stat(path + ".json")  // Check if a ".json" file exists with the same name
+		fi, err = os.Stat(path + d.ext)  // Check if a record file exists with the same name
 	}
 	return
 }
@@ -0,0 +1,675 @@
+package main
+
+import (
+	"bytes"         // For comparing stored records byte for byte
+	"compress/gzip" // For planting compressed record files
+	"context"       // For streaming typed records
+	"encoding/xml"  // For a codec other than JSON
+	"errors"        // For matching error sentinels
+	"fmt"           // For naming benchmark cases and records
+	"io"            // For draining record streams
+	"os"            // For creating symlinks and checking files on disk
+	"path/filepath" // For building paths in the test database
+	"strings"       // For building records of a given size
+	"sync"          // For waiting on concurrent readers and writers
+	"sync/atomic"   // For giving parallel benchmark writers distinct record names
+	"testing"       // For the testing framework
+	"time"          // For lock timeouts and holding locks
+)
+
+// Helper function to open a driver on a fresh temporary directory, removed when the test ends
+func newTestDriver(t testing.TB, options *Options) *Driver {
+	t.Helper()
+	db, err := New(t.TempDir(), options)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return db
+}
+
+func TestMissingCollection(t *testing.T) {
+	db := newTestDriver(t, nil)
+	if err := db.Insert("users", "John", User{Name: "John"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var user User
+	err := db.Read("missing", "John", &user)
+	if !errors.Is(err, ErrCollectionNotFound) || !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("Read from a missing collection = %v, want ErrCollectionNotFound and ErrRecordNotFound", err)
+	}
+	if _, err := db.ReadAll("missing"); !errors.Is(err, ErrCollectionNotFound) {
+		t.Errorf("ReadAll of a missing collection = %v, want ErrCollectionNotFound", err)
+	}
+
+	// A missing record in a collection that exists is only a missing record
+	err = db.Read("users", "Jane", &user)
+	if !errors.Is(err, ErrRecordNotFound) || errors.Is(err, ErrCollectionNotFound) {
+		t.Errorf("Read of a missing record = %v, want ErrRecordNotFound without ErrCollectionNotFound", err)
+	}
+	if records, err := db.ReadAll("users"); err != nil || len(records) != 1 {
+		t.Errorf("ReadAll(users) = %d records, %v, want 1", len(records), err)
+	}
+
+	// MissingAsEmpty turns a missing collection into an empty one for listings
+	db = newTestDriver(t, &Options{MissingAsEmpty: true})
+	if records, err := db.ReadAll("missing"); err != nil || len(records) != 0 {
+		t.Errorf("ReadAll of a missing collection with MissingAsEmpty = %q, %v, want none", records, err)
+	}
+}
+
+func TestSymlinks(t *testing.T) {
+	for _, sharded := range []bool{false, true} {
+		db := newTestDriver(t, &Options{Sharded: sharded})
+
+		// A collection whose directory is a link to a directory elsewhere (e.g. a mounted volume)
+		volume := t.TempDir()
+		if err := os.Symlink(volume, filepath.Join(db.dir, "linked")); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"Ann", "Bob"} {
+			if err := db.Insert("linked", name, User{Name: name}); err != nil {
+				t.Fatalf("sharded=%v: Insert into a linked collection: %v", sharded, err)
+			}
+		}
+		if records, err := db.ReadAll("linked"); err != nil || len(records) != 2 {
+			t.Fatalf("sharded=%v: ReadAll of a linked collection = %d records, %v, want 2", sharded, len(records), err)
+		}
+		if n, err := db.Count("linked"); err != nil || n != 2 {
+			t.Fatalf("sharded=%v: Count of a linked collection = %d, %v, want 2", sharded, n, err)
+		}
+		if err := db.Delete("linked", "Ann"); err != nil {
+			t.Fatalf("sharded=%v: Delete from a linked collection: %v", sharded, err)
+		}
+
+		// Deleting the collection removes the link and leaves the volume alone
+		if err := db.Delete("linked", ""); err != nil {
+			t.Fatalf("sharded=%v: Delete of a linked collection: %v", sharded, err)
+		}
+		if _, err := os.Lstat(filepath.Join(db.dir, "linked")); !os.IsNotExist(err) {
+			t.Fatalf("sharded=%v: link still there after deleting the collection: %v", sharded, err)
+		}
+		if entries, err := os.ReadDir(volume); err != nil || len(entries) == 0 {
+			t.Fatalf("sharded=%v: linked volume emptied by deleting the collection: %v", sharded, err)
+		}
+	}
+
+	// A record whose file is a link is read like the file it points to, and Delete only drops the link
+	db := newTestDriver(t, nil)
+	if err := db.Insert("users", "Ann", User{Name: "Ann"}); err != nil {
+		t.Fatal(err)
+	}
+	target := filepath.Join(t.TempDir(), "Bob.json")
+	if err := os.WriteFile(target, []byte(`{"Name": "Bob"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(target, filepath.Join(db.dir, "users", "Bob.json")); err != nil {
+		t.Fatal(err)
+	}
+	var user User
+	if err := db.Read("users", "Bob", &user); err != nil || user.Name != "Bob" {
+		t.Fatalf("Read of a linked record = %q, %v", user.Name, err)
+	}
+	if records, err := db.ReadAll("users"); err != nil || len(records) != 2 {
+		t.Fatalf("ReadAll with a linked record = %d records, %v, want 2", len(records), err)
+	}
+	if err := db.Delete("users", "Bob"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(target); err != nil {
+		t.Fatalf("linked record's target removed by Delete: %v", err)
+	}
+
+	// A dangling link is an error, not a record that silently can't be read
+	if err := os.Symlink(filepath.Join(t.TempDir(), "gone.json"), filepath.Join(db.dir, "users", "Gone.json")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ReadAll("users"); err == nil {
+		t.Fatal("ReadAll with a dangling link succeeded, want an error")
+	}
+}
+
+// Sizes of the records used by the benchmarks, as the length of their Name field
+var benchSizes = []int{100, 10 << 10, 100 << 10}
+
+// Helper function to build a record whose encoding is roughly size bytes
+func benchRecord(size int) User {
+	return User{Name: strings.Repeat("x", size), Age: "30", Contact: "555-0100", Company: "Acme"}
+}
+
+// Benchmarks Insert, which marshals the record, then writes a temporary file and renames it under
+// the collection's write lock; the parallel case shows how much the lock serializes writers
+func BenchmarkWrite(b *testing.B) {
+	for _, size := range benchSizes {
+		record := benchRecord(size)
+		b.Run(fmt.Sprintf("serial/%dB", size), func(b *testing.B) {
+			db := newTestDriver(b, nil)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := db.Insert("users", fmt.Sprint(i%1000), record); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("parallel/%dB", size), func(b *testing.B) {
+			db := newTestDriver(b, nil)
+			var n atomic.Int64
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if err := db.Insert("users", fmt.Sprint(n.Add(1)%1000), record); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+// Benchmarks Read, which only takes the collection's read lock, so parallel readers don't wait on each other
+func BenchmarkRead(b *testing.B) {
+	for _, size := range benchSizes {
+		db := newTestDriver(b, nil)
+		if err := db.Insert("users", "John", benchRecord(size)); err != nil {
+			b.Fatal(err)
+		}
+		b.Run(fmt.Sprintf("serial/%dB", size), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				var user User
+				if err := db.Read("users", "John", &user); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("parallel/%dB", size), func(b *testing.B) {
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					var user User
+					if err := db.Read("users", "John", &user); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+// Benchmarks ReadAll on collections of different sizes
+func BenchmarkReadAll(b *testing.B) {
+	for _, count := range []int{10, 100, 1000} {
+		db := newTestDriver(b, nil)
+		for i := 0; i < count; i++ {
+			if err := db.Insert("users", fmt.Sprint(i), benchRecord(100)); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.Run(fmt.Sprintf("%drecords", count), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := db.ReadAll("users"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// Benchmarks Delete, writing each record back outside the timer
+func BenchmarkDelete(b *testing.B) {
+	db := newTestDriver(b, nil)
+	record := benchRecord(100)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		if err := db.Insert("users", "John", record); err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+		if err := db.Delete("users", "John"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestMaxRecordBytes(t *testing.T) {
+	db := newTestDriver(t, &Options{MaxRecordBytes: 200})
+
+	if err := db.Insert("users", "Small", User{Name: "Ann"}); err != nil {
+		t.Fatalf("Insert under the limit: %v", err)
+	}
+	if err := db.Insert("users", "Big", benchRecord(300)); !errors.Is(err, ErrRecordTooLarge) {
+		t.Fatalf("Insert over the limit = %v, want ErrRecordTooLarge", err)
+	}
+	if _, err := os.Stat(filepath.Join(db.dir, "users", "Big.json")); !os.IsNotExist(err) {
+		t.Fatalf("record over the limit was written: %v", err)
+	}
+
+	// A file grown past the limit on disk is refused rather than loaded
+	big := []byte(`{"Name": "` + strings.Repeat("x", 300) + `"}`)
+	if err := os.WriteFile(filepath.Join(db.dir, "users", "Grown.json"), big, 0644); err != nil {
+		t.Fatal(err)
+	}
+	var user User
+	if err := db.Read("users", "Grown", &user); !errors.Is(err, ErrRecordTooLarge) {
+		t.Fatalf("Read of a file over the limit = %v, want ErrRecordTooLarge", err)
+	}
+	if _, err := db.ReadAll("users"); !errors.Is(err, ErrRecordTooLarge) {
+		t.Fatalf("ReadAll with a file over the limit = %v, want ErrRecordTooLarge", err)
+	}
+	if err := db.Read("users", "Small", &user); err != nil || user.Name != "Ann" {
+		t.Fatalf("Read under the limit = %q, %v", user.Name, err)
+	}
+}
+
+func TestMaxRecordBytesEncrypted(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	record := benchRecord(100)
+	b, err := newTestDriver(t, nil).marshalRecord(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A record right at the limit is stored with a nonce and tag on top, and must still read back
+	db := newTestDriver(t, &Options{EncryptionKey: key, MaxRecordBytes: len(b)})
+	if err := db.Insert("users", "Full", record); err != nil {
+		t.Fatalf("Insert at the limit: %v", err)
+	}
+	var user User
+	if err := db.Read("users", "Full", &user); err != nil || user.Name != record.Name {
+		t.Fatalf("Read at the limit = %q, %v", user.Name, err)
+	}
+	if _, err := db.ReadAll("users"); err != nil {
+		t.Fatalf("ReadAll at the limit: %v", err)
+	}
+	stream, err := db.ReadStream("users", "Full")
+	if err != nil {
+		t.Fatalf("ReadStream at the limit: %v", err)
+	}
+	stream.Close()
+
+	// A record one byte over is still refused, on write and on read
+	if err := db.Insert("users", "Over", benchRecord(101)); !errors.Is(err, ErrRecordTooLarge) {
+		t.Fatalf("Insert over the limit = %v, want ErrRecordTooLarge", err)
+	}
+	small, err := New(db.dir, &Options{EncryptionKey: key, MaxRecordBytes: len(b) - 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := small.Read("users", "Full", &user); !errors.Is(err, ErrRecordTooLarge) {
+		t.Fatalf("Read with a lower limit = %v, want ErrRecordTooLarge", err)
+	}
+}
+
+func TestMaxRecordBytesCompressed(t *testing.T) {
+	db := newTestDriver(t, &Options{Compress: true, MaxRecordBytes: 10000})
+	if err := db.Insert("users", "Small", User{Name: "Ann"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A tiny file on disk that inflates far past the limit is refused, not loaded
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	fmt.Fprintf(gz, `{"Name": "%s"}`, strings.Repeat("x", 1<<20))
+	gz.Close()
+	if err := os.WriteFile(filepath.Join(db.dir, "users", "Bomb.json.gz"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var user User
+	if err := db.Read("users", "Bomb", &user); !errors.Is(err, ErrRecordTooLarge) {
+		t.Fatalf("Read of a record inflating past the limit = %v, want ErrRecordTooLarge", err)
+	}
+	stream, err := db.ReadStream("users", "Bomb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	if b, err := io.ReadAll(stream); !errors.Is(err, ErrRecordTooLarge) || len(b) > 10000 {
+		t.Fatalf("streaming a record inflating past the limit read %d bytes, %v, want ErrRecordTooLarge", len(b), err)
+	}
+
+	if err := db.Read("users", "Small", &user); err != nil || user.Name != "Ann" {
+		t.Fatalf("Read under the limit = %q, %v", user.Name, err)
+	}
+}
+
+// Codec storing records as XML, to check that reads don't assume JSON
+type xmlCodec struct{}
+
+func (xmlCodec) Marshal(v interface{}) ([]byte, error)   { return xml.Marshal(v) }
+func (xmlCodec) Unmarshal(b []byte, v interface{}) error { return xml.Unmarshal(b, v) }
+func (xmlCodec) Extension() string                       { return ".xml" }
+
+func TestTypedReadsUseCodec(t *testing.T) {
+	db := newTestDriver(t, &Options{Codec: xmlCodec{}})
+	for _, name := range []string{"Jane", "John"} {
+		if err := db.Insert("users", name, User{Name: name, Age: "30"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var users []User
+	if err := db.ReadAllInto("users", &users); err != nil || len(users) != 2 || users[0].Name != "Jane" {
+		t.Errorf("ReadAllInto = %+v, %v, want Jane and John", users, err)
+	}
+
+	records, errc := StreamTyped[User](db, context.Background(), "users")
+	var names []string
+	for user := range records {
+		names = append(names, user.Name)
+	}
+	if err := <-errc; err != nil || len(names) != 2 || names[1] != "John" {
+		t.Errorf("StreamTyped = %q, %v, want Jane and John", names, err)
+	}
+}
+
+func TestStreamTypedHoldsReadLock(t *testing.T) {
+	db := newTestDriver(t, &Options{LockTimeout: 50 * time.Millisecond})
+	for _, name := range []string{"Jane", "John"} {
+		if err := db.Insert("users", name, User{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A write waits for a stream in progress instead of changing records under it
+	ctx, cancel := context.WithCancel(context.Background())
+	records, errc := StreamTyped[User](db, ctx, "users")
+	<-records
+	if err := db.Delete("users", "John"); !errors.Is(err, ErrLockTimeout) {
+		t.Errorf("Delete during a stream = %v, want ErrLockTimeout", err)
+	}
+
+	// Cancelling the stream lets it go
+	cancel()
+	for range records {
+	}
+	<-errc
+	if err := db.Delete("users", "John"); err != nil {
+		t.Errorf("Delete after the stream was cancelled = %v", err)
+	}
+}
+
+func TestNamespaceIsolation(t *testing.T) {
+	db := newTestDriver(t, nil)
+	alpha, err := db.Namespace("alpha")
+	if err != nil {
+		t.Fatal(err)
+	}
+	beta, err := db.Namespace("beta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := alpha.Insert("users", "Ann", User{Name: "Ann"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Insert("users", "Root", User{Name: "Root"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The other tenant doesn't see the record, and neither does the driver's own collection
+	var user User
+	if err := beta.Read("users", "Ann", &user); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("beta reading alpha's record = %v, want ErrRecordNotFound", err)
+	}
+	if err := db.Read("users", "Ann", &user); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("driver reading alpha's record = %v, want ErrRecordNotFound", err)
+	}
+	if err := alpha.Read("users", "Ann", &user); err != nil || user.Name != "Ann" {
+		t.Errorf("alpha reading its own record = %q, %v", user.Name, err)
+	}
+
+	// Crafted names can't reach another tenant or the driver's collections
+	for _, name := range []string{"..", ".", "../beta", "../../users", ".namespaces", "a/b", `a\b`, "a\x00b"} {
+		if _, err := db.Namespace(name); !errors.Is(err, ErrInvalidNamespace) {
+			t.Errorf("Namespace(%q) = %v, want ErrInvalidNamespace", name, err)
+		}
+		if err := beta.Insert(name, "Ann", User{Name: "Eve"}); !errors.Is(err, ErrInvalidNamespace) {
+			t.Errorf("beta.Insert(%q) = %v, want ErrInvalidNamespace", name, err)
+		}
+		if _, err := beta.ReadAll(name); !errors.Is(err, ErrInvalidNamespace) {
+			t.Errorf("beta.ReadAll(%q) = %v, want ErrInvalidNamespace", name, err)
+		}
+		if err := beta.Delete(name, "Ann"); !errors.Is(err, ErrInvalidNamespace) {
+			t.Errorf("beta.Delete(%q) = %v, want ErrInvalidNamespace", name, err)
+		}
+	}
+	for _, resource := range []string{"../Ann", "../../../users/Root"} {
+		if err := beta.Read("users", resource, &user); err == nil {
+			t.Errorf("beta.Read(users, %q) succeeded, want an error", resource)
+		}
+		if err := beta.Delete("users", resource); err == nil {
+			t.Errorf("beta.Delete(users, %q) succeeded, want an error", resource)
+		}
+	}
+	if err := beta.Delete("", "users"); err == nil {
+		t.Error("beta.Delete with no collection succeeded, want an error")
+	}
+
+	// Everything is still where it was
+	if err := alpha.Read("users", "Ann", &user); err != nil || user.Name != "Ann" {
+		t.Errorf("alpha's record after beta's attempts = %q, %v", user.Name, err)
+	}
+	if err := db.Read("users", "Root", &user); err != nil || user.Name != "Root" {
+		t.Errorf("driver's record after beta's attempts = %q, %v", user.Name, err)
+	}
+}
+
+func TestCanonicalRecords(t *testing.T) {
+	db := newTestDriver(t, &Options{Canonical: true})
+	path := filepath.Join(db.dir, "users", "John.json")
+	readFile := func() []byte {
+		t.Helper()
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	// Writing the same data twice gives the same bytes
+	user := User{Name: "John", Age: "30", Company: "Acme", Address: Address{City: "Pune"}}
+	if err := db.Insert("users", "John", user); err != nil {
+		t.Fatal(err)
+	}
+	first := readFile()
+	if err := db.Insert("users", "John", user); err != nil {
+		t.Fatal(err)
+	}
+	if second := readFile(); !bytes.Equal(first, second) {
+		t.Fatalf("rewriting an unchanged record changed it:\n%s\n%s", first, second)
+	}
+
+	// A map holding the same fields is stored exactly like the struct, whatever order it was built in
+	record := map[string]interface{}{}
+	for _, key := range []string{"Address", "Contact", "Company", "Age", "Name"} {
+		switch key {
+		case "Name":
+			record[key] = "John"
+		case "Age":
+			record[key] = 30
+		case "Company":
+			record[key] = "Acme"
+		case "Contact":
+			record[key] = ""
+		case "Address":
+			record[key] = map[string]interface{}{"Pincode": 0, "State": "", "Country": "", "City": "Pune"}
+		}
+	}
+	if err := db.Insert("users", "John", record); err != nil {
+		t.Fatal(err)
+	}
+	if third := readFile(); !bytes.Equal(first, third) {
+		t.Fatalf("map record stored differently from the struct:\n%s\n%s", first, third)
+	}
+}
+
+func TestCount(t *testing.T) {
+	for _, sharded := range []bool{false, true} {
+		db := newTestDriver(t, &Options{Sharded: sharded})
+		if _, err := db.Count("users"); !errors.Is(err, ErrCollectionNotFound) {
+			t.Fatalf("sharded=%v: Count of a missing collection = %v, want ErrCollectionNotFound", sharded, err)
+		}
+		if err := os.MkdirAll(filepath.Join(db.dir, "users"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if n, err := db.Count("users"); err != nil || n != 0 {
+			t.Fatalf("sharded=%v: Count of an empty collection = %d, %v, want 0", sharded, n, err)
+		}
+
+		// Writers running alongside are either counted or not, never seen halfway through a write
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < 50; i++ {
+				if err := db.Insert("users", fmt.Sprint(i), User{Name: fmt.Sprint(i)}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+		last := 0
+		for running := true; running; {
+			select {
+			case <-done:
+				running = false
+			default:
+			}
+			n, err := db.Count("users")
+			if err != nil || n < last || n > 50 {
+				t.Fatalf("sharded=%v: Count during writes = %d, %v after %d", sharded, n, err, last)
+			}
+			last = n
+		}
+		if n, err := db.Count("users"); err != nil || n != 50 {
+			t.Fatalf("sharded=%v: Count = %d, %v, want 50", sharded, n, err)
+		}
+	}
+}
+
+func TestLockTimeout(t *testing.T) {
+	db := newTestDriver(t, &Options{LockTimeout: time.Second})
+
+	// Readers overlapping one another never leave the collection unlocked, yet a writer still gets in
+	stop := make(chan struct{})
+	var readers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				mutex, err := db.rlockCollection("users")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				time.Sleep(5 * time.Millisecond)
+				mutex.RUnlock()
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	err := db.Insert("users", "John", User{Name: "John"})
+	close(stop)
+	readers.Wait()
+	if err != nil {
+		t.Fatalf("Insert among a stream of readers = %v", err)
+	}
+
+	// A write held past the timeout fails others with ErrLockTimeout, or ctx.Err() once ctx ends
+	mutex, err := db.lockCollection("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var user User
+	if err := db.Read("users", "John", &user); !errors.Is(err, ErrLockTimeout) {
+		t.Errorf("Read of a locked collection = %v, want ErrLockTimeout", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := db.InsertContext(ctx, "users", "Jane", User{Name: "Jane"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("InsertContext into a locked collection = %v, want context.DeadlineExceeded", err)
+	}
+
+	// Giving up leaves nothing behind, so the collection is usable once the write is done
+	mutex.Unlock()
+	if err := db.Read("users", "John", &user); err != nil || user.Name != "John" {
+		t.Errorf("Read after the lock was released = %+v, %v", user, err)
+	}
+}
+
+func TestDropCollectionDuringInserts(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	// Inserts queued behind a drop must not run alongside callers holding the collection's new lock
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if err := db.Insert("users", "John", User{Name: "John"}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if err := db.DropCollection("users"); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestChecksums(t *testing.T) {
+	db := newTestDriver(t, &Options{VerifyChecksums: true})
+	if err := db.Insert("users", "John", User{Name: "John"}); err != nil {
+		t.Fatal(err)
+	}
+	record := filepath.Join(db.dir, "users", "John.json")
+	if _, err := os.Stat(record + ".sum"); err != nil {
+		t.Fatalf("no checksum sidecar with VerifyChecksums: %v", err)
+	}
+
+	// A record changed behind the driver's back fails verification under either error name
+	if err := os.WriteFile(record, []byte(`{"Name": "Jo`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var user User
+	err := db.Read("users", "John", &user)
+	if !errors.Is(err, ErrChecksumMismatch) || !errors.Is(err, ErrCorrupted) {
+		t.Fatalf("Read of a corrupted record = %v, want ErrChecksumMismatch", err)
+	}
+
+	// A checksum staged by a write that crashed is cleaned up with the record
+	for _, remove := range []func() error{
+		func() error { return db.Delete("users", "John") },
+		func() error { return db.SoftDelete("users", "John") },
+	} {
+		if err := db.Insert("users", "John", User{Name: "John"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(record+".sum.tmp", []byte("stale\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := remove(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(record + ".sum.tmp"); !os.IsNotExist(err) {
+			t.Fatalf("staged checksum left behind: %v", err)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors" // For recognizing missing records
+	"fmt"    // For formatted error messages
+	"os"     // For recognizing missing records
+)
+
+// Method to read a set of records by resource name, keyed by resource name
+// The collection's read lock is taken once for the whole set. With failOnMissing set, a resource
+// that doesn't exist fails the call with ErrRecordNotFound; otherwise it is left out of the map.
+func (d *Driver) GetMany(collection string, resources []string, failOnMissing bool) (map[string][]byte, error) {
+	// Validate that a collection name is provided
+	if collection == "" {
+		return nil, fmt.Errorf("%w - unable to read records", ErrMissingCollection)
+	}
+
+	// Resolve every name before taking the lock, rejecting invalid ones up front
+	paths := make([]string, len(resources))
+	for i, resource := range resources {
+		if resource == "" {
+			return nil, fmt.Errorf("%w - unable to read record (no name)", ErrMissingResource)
+		}
+		record, err := d.resolvePath(collection, resource)
+		if err != nil {
+			return nil, err
+		}
+		paths[i] = record
+	}
+
+	mutex, err := d.rlockCollection(collection)
+	if err != nil {
+		return nil, err
+	}
+	defer mutex.RUnlock()
+
+	records := make(map[string][]byte, len(resources))
+	for i, resource := range resources {
+		// A file that belongs to a different resource name with the same encoding doesn't count
+		err := d.checkKey(paths[i], resource)
+		var b []byte
+		if err == nil {
+			b, err = d.readRecord(paths[i])
+		}
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, ErrKeyCollision) {
+			if failOnMissing {
+				return nil, d.readError(collection, resource, os.ErrNotExist)
+			}
+			continue
+		}
+		if err != nil {
+			return nil, d.readError(collection, resource, err)
+		}
+		records[resource] = b
+	}
+	return records, nil
+}
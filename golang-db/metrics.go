@@ -0,0 +1,51 @@
+package main
+
+import (
+	"sync/atomic" // For counting without taking locks in the hot paths
+)
+
+// Struct holding the counters a Driver keeps since it was created
+type driverMetrics struct {
+	inserts      atomic.Uint64
+	reads        atomic.Uint64
+	readAlls     atomic.Uint64
+	deletes      atomic.Uint64
+	errors       atomic.Uint64
+	bytesWritten atomic.Uint64
+	bytesRead    atomic.Uint64
+}
+
+// Struct representing a point-in-time copy of a Driver's counters
+type DriverMetrics struct {
+	Inserts      uint64 // Calls to Insert
+	Reads        uint64 // Calls to Read
+	ReadAlls     uint64 // Calls to ReadAll
+	Deletes      uint64 // Calls to Delete
+	Errors       uint64 // Calls to any of the above that returned an error
+	BytesWritten uint64 // Record bytes written to disk by every kind of write
+	BytesRead    uint64 // Record bytes read from disk by every kind of read
+}
+
+// Method to take a snapshot of the driver's counters
+// Each counter is read atomically, but the snapshot as a whole isn't, so counters updated while it
+// is taken may be one operation apart.
+func (d *Driver) MetricsSnapshot() DriverMetrics {
+	return DriverMetrics{
+		Inserts:      d.metrics.inserts.Load(),
+		Reads:        d.metrics.reads.Load(),
+		ReadAlls:     d.metrics.readAlls.Load(),
+		Deletes:      d.metrics.deletes.Load(),
+		Errors:       d.metrics.errors.Load(),
+		BytesWritten: d.metrics.bytesWritten.Load(),
+		BytesRead:    d.metrics.bytesRead.Load(),
+	}
+}
+
+// Helper to count a call to a public method, and its error if it failed
+// Meant to be deferred with a pointer to the method's named error result
+func (m *driverMetrics) observe(calls *atomic.Uint64, err *error) {
+	calls.Add(1)
+	if *err != nil {
+		m.errors.Add(1)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"         // For comparing the record before and after the transform
+	"encoding/json" // For reading and stamping the version marker
+	"fmt"           // For formatted error messages
+	"path/filepath" // For file path operations
+	"strconv"       // For encoding the version marker
+)
+
+// Key under which the schema version marker is stored inside a migrated record
+const versionKey = "_version"
+
+// Method to migrate every record in a collection through a transform function
+// Each record is rewritten atomically under the collection lock, and only records whose contents
+// actually changed are written back and counted
+func (d *Driver) Migrate(collection string, fn func(raw []byte) ([]byte, error)) (migrated int, err error) {
+	return d.migrate(collection, 0, fn)
+}
+
+// Method to migrate every record in a collection to a given schema version
+// Records already stamped with this version (or a newer one) are skipped, and every migrated record
+// is stamped with the version, so running the same migration twice is a no-op
+func (d *Driver) MigrateToVersion(collection string, version int, fn func(raw []byte) ([]byte, error)) (migrated int, err error) {
+	// Version 0 is reserved for records that have never been migrated
+	if version <= 0 {
+		return 0, fmt.Errorf("Invalid Version - migration version must be greater than zero")
+	}
+	return d.migrate(collection, version, fn)
+}
+
+// Helper shared by Migrate and MigrateToVersion, a version of 0 disables the version marker
+func (d *Driver) migrate(collection string, version int, fn func(raw []byte) ([]byte, error)) (int, error) {
+	// Refuse to write when the driver was opened read-only
+	if d.readOnly {
+		return 0, ErrReadOnly
+	}
+
+	// Validate that a collection name is provided
+	if collection == "" {
+		return 0, fmt.Errorf("%w - unable to migrate records", ErrMissingCollection)
+	}
+
+	// Hold the collection mutex for the whole migration so no write interleaves with it
+	mutex, err := d.lockCollection(collection)
+	if err != nil {
+		return 0, err
+	}
+	defer mutex.Unlock()
+
+	// Check if the collection directory exists
+	dir, err := d.collectionDir(collection)
+	if err != nil {
+		return 0, err
+	}
+
+	files, err := d.recordFiles(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	migrated := 0
+	for _, path := range files {
+		// Only records are migrated, temporary files are skipped
+		if !d.isRecordFile(path) {
+			continue
+		}
+
+		raw, err := d.readRecord(path)
+		if err != nil {
+			return migrated, err
+		}
+
+		// Skip records that were already migrated to this version
+		if version > 0 && recordVersion(raw) >= version {
+			continue
+		}
+
+		// Run the record through the transform function
+		out, err := fn(raw)
+		if err != nil {
+			return migrated, fmt.Errorf("unable to migrate %v: %w", filepath.Base(path), err)
+		}
+
+		// Stamp the version marker so the record is skipped next time
+		if version > 0 {
+			if out, err = stampVersion(out, version); err != nil {
+				return migrated, fmt.Errorf("unable to stamp version on %v: %w", filepath.Base(path), err)
+			}
+		}
+
+		// Unchanged records are left untouched on disk
+		if bytes.Equal(out, raw) {
+			continue
+		}
+
+		if err := d.writeRecord(path, out); err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+	return migrated, nil
+}
+
+// Helper function to read the version marker of a record
+// Records without a marker (or that are not JSON objects) are treated as version 0
+func recordVersion(raw []byte) int {
+	var marker struct {
+		Version int `json:"_version"`
+	}
+	if err := json.Unmarshal(raw, &marker); err != nil {
+		return 0
+	}
+	return marker.Version
+}
+
+// Helper function to write the version marker into a record
+// The record is re-encoded in the same indented format Insert uses
+func stampVersion(raw []byte, version int) ([]byte, error) {
+	var record map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &record); err != nil {
+		return nil, err
+	}
+	record[versionKey] = json.RawMessage(strconv.Itoa(version))
+
+	b, err := json.MarshalIndent(record, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, byte('\n')), nil
+}
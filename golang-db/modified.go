@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors" // For skipping records deleted mid-scan
+	"fmt"    // For formatted error messages
+	"os"     // For reading file modification times
+	"time"   // For comparing modification times
+)
+
+// Method to read the records of a collection written at or after a point in time, keyed by resource name
+// Writes go to a temporary file that is then renamed over the record, and a rename keeps the
+// temporary file's modification time, so a record's ModTime is the time of its last write.
+// Deleted records simply stop showing up; callers that need to see deletions must compare keys.
+// Timestamps come from the filesystem, whose clock can trail time.Now by a few milliseconds, so a
+// client polling for changes should pass a since slightly before its previous poll and expect overlap.
+func (d *Driver) ReadModifiedSince(collection string, since time.Time) (map[string]string, error) {
+	// Validate that a collection name is provided
+	if collection == "" {
+		return nil, fmt.Errorf("%w - unable to read records", ErrMissingCollection)
+	}
+
+	// Construct the directory path for the collection and check that it exists
+	dir, err := d.collectionDir(collection)
+	if err != nil {
+		return nil, d.emptyIfMissing(err)
+	}
+
+	files, err := d.recordFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make(map[string]string)
+	for _, file := range files {
+		if !d.isRecordFile(file) {
+			continue // Skip temporary files
+		}
+
+		// Check the modification time before reading, so unchanged records cost a single stat
+		fi, err := os.Stat(file)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if fi.ModTime().Before(since) {
+			continue
+		}
+
+		b, err := d.readRecord(file)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		records[d.keyOf(file)] = string(b)
+	}
+	return records, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"        // For the namespace error sentinel
+	"fmt"           // For formatted error messages
+	"path/filepath" // For building namespaced collection paths
+	"strings"       // For checking names for path separators
+)
+
+// Name of the directory, at the top of the database, that holds one subdirectory per namespace
+// It starts with a dot so it never shows up as a collection of the database itself
+const namespacesDirName = ".namespaces"
+
+// Error returned when a namespace or namespaced collection name could reach outside its directory
+var ErrInvalidNamespace = errors.New("invalid namespace or collection name")
+
+// Struct representing a handle on one namespace (e.g. a tenant) of a Driver
+// Its collections are stored under <dir>/.namespaces/<name>/ and share the driver's options, locks
+// and metrics. Collection names are checked so no operation can reach another namespace's files
+// or the collections of the driver itself.
+type Scoped struct {
+	d    *Driver // Driver doing the actual work
+	name string  // Name of the namespace directory
+}
+
+// Method to get a handle scoped to a namespace
+// The name must be a plain directory name: no path separators, no leading dot and not "." or "..".
+// Nothing is created until the first record is inserted.
+func (d *Driver) Namespace(name string) (*Scoped, error) {
+	if err := checkNamespaceName(name); err != nil {
+		return nil, err
+	}
+	return &Scoped{d: d, name: name}, nil
+}
+
+// Method to insert a record into a collection of the namespace (see Driver.Insert)
+func (s *Scoped) Insert(collection, resource string, v interface{}) error {
+	path, err := s.collection(collection)
+	if err != nil {
+		return err
+	}
+	return s.d.Insert(path, resource, v)
+}
+
+// Method to read a record from a collection of the namespace (see Driver.Read)
+func (s *Scoped) Read(collection, resource string, v interface{}) error {
+	path, err := s.collection(collection)
+	if err != nil {
+		return err
+	}
+	return s.d.Read(path, resource, v)
+}
+
+// Method to read every record of a collection of the namespace (see Driver.ReadAll)
+func (s *Scoped) ReadAll(collection string) ([]string, error) {
+	path, err := s.collection(collection)
+	if err != nil {
+		return nil, err
+	}
+	return s.d.ReadAll(path)
+}
+
+// Method to delete a record, or a whole collection, of the namespace (see Driver.Delete)
+func (s *Scoped) Delete(collection, resource string) error {
+	path, err := s.collection(collection)
+	if err != nil {
+		return err
+	}
+	return s.d.Delete(path, resource)
+}
+
+// Helper function to map a collection name onto its path relative to the database directory
+// An empty name is passed through so the driver reports it the usual way
+func (s *Scoped) collection(collection string) (string, error) {
+	if collection == "" {
+		return "", nil
+	}
+	if err := checkNamespaceName(collection); err != nil {
+		return "", err
+	}
+	return filepath.Join(namespacesDirName, s.name, collection), nil
+}
+
+// Helper function to check that a name refers to a single, non-hidden directory
+func checkNamespaceName(name string) error {
+	switch {
+	case name == "", name == ".", name == "..", strings.HasPrefix(name, "."):
+		return fmt.Errorf("%q: %w", name, ErrInvalidNamespace)
+	case strings.ContainsAny(name, "/\\\x00"):
+		return fmt.Errorf("%q: %w", name, ErrInvalidNamespace)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"sort"    // For ordering the listed records
+	"strings" // For trimming leading zeros
+)
+
+// Helper function to order record files by resource name
+// Shard directories scatter records by hash, so files are always sorted by their base name, using
+// the caller's Order if one was configured and plain lexical order otherwise
+func (d *Driver) sortFiles(files []string) {
+	less := d.order
+	if less == nil {
+		less = func(a, b string) bool { return a < b }
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		return less(d.resourceName(files[i]), d.resourceName(files[j]))
+	})
+}
+
+// Function comparing two resource names in natural (human) order
+// Runs of digits are compared by their numeric value, so "user2" sorts before "user10".
+// Pass it as Options.Order to get stable, intuitive ordering from ReadAll.
+func NaturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		if isDigit(a[0]) && isDigit(b[0]) {
+			// Compare the whole run of digits as a number
+			numA, restA := splitDigits(a)
+			numB, restB := splitDigits(b)
+
+			trimmedA, trimmedB := strings.TrimLeft(numA, "0"), strings.TrimLeft(numB, "0")
+			if len(trimmedA) != len(trimmedB) {
+				return len(trimmedA) < len(trimmedB)
+			}
+			if trimmedA != trimmedB {
+				return trimmedA < trimmedB
+			}
+
+			// Equal values, so the one with fewer leading zeros goes first
+			if len(numA) != len(numB) {
+				return len(numA) < len(numB)
+			}
+			a, b = restA, restB
+			continue
+		}
+
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+// Helper function to report whether a byte is an ASCII digit
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// Helper function to split a string into its leading run of digits and the rest
+func splitDigits(s string) (string, string) {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt" // For formatted error messages
+)
+
+// Method to read one page of a collection: the records from offset up to offset+limit, in ReadAll order
+// Only the files in the window are read, so walking a large collection page by page keeps memory
+// flat. An offset past the end gives an empty page, and a limit of 0 or less reads to the end.
+// Leftover temporary files don't count towards the offset.
+func (d *Driver) ReadAllPaged(collection string, offset, limit int) ([]string, error) {
+	// Validate that a collection name is provided
+	if collection == "" {
+		return nil, fmt.Errorf("%w - unable to read records", ErrMissingCollection)
+	}
+	if offset < 0 {
+		return nil, fmt.Errorf("offset must not be negative, got %d", offset)
+	}
+
+	// Hold the collection's read lock, so no record is read halfway through a write
+	mutex, err := d.rlockCollection(collection)
+	if err != nil {
+		return nil, err
+	}
+	defer mutex.RUnlock()
+
+	// Construct the directory path for the collection and check that it exists
+	dir, err := d.collectionDir(collection)
+	if err != nil {
+		if err = d.emptyIfMissing(err); err != nil {
+			return nil, err
+		}
+		return []string{}, nil
+	}
+
+	files, err := d.recordFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Narrow the listing down to the requested window before reading anything
+	var window []string
+	for _, file := range files {
+		if d.isRecordFile(file) {
+			window = append(window, file)
+		}
+	}
+	if offset >= len(window) {
+		return []string{}, nil
+	}
+	window = window[offset:]
+	if limit > 0 && limit < len(window) {
+		window = window[:limit]
+	}
+
+	records := make([]string, 0, len(window))
+	for _, file := range window {
+		b, err := d.readRecord(file)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, string(b))
+	}
+	return records, nil
+}
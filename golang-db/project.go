@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"         // For streaming over a record's contents
+	"encoding/json" // For decoding records token by token
+	"fmt"           // For formatted error messages
+)
+
+// Method to read only some top-level fields of every record in a collection
+// Each record is walked with a streaming decoder and only the requested fields are decoded, the rest
+// are skipped over, so large records don't have to be unmarshalled in full. Fields a record doesn't
+// have are left out of its map.
+func (d *Driver) Project(collection string, fields []string) ([]map[string]interface{}, error) {
+	// Validate that a collection name is provided
+	if collection == "" {
+		return nil, fmt.Errorf("%w - unable to read records", ErrMissingCollection)
+	}
+
+	// Construct the directory path for the collection and check that it exists
+	dir, err := d.collectionDir(collection)
+	if err != nil {
+		return nil, d.emptyIfMissing(err)
+	}
+
+	files, err := d.recordFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Look the requested fields up by name while decoding
+	wanted := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		wanted[field] = true
+	}
+
+	projections := []map[string]interface{}{}
+	for _, file := range files {
+		if !d.isRecordFile(file) {
+			continue // Skip temporary files
+		}
+
+		// Read the record, verifying its checksum if enabled
+		b, err := d.readRecord(file)
+		if err != nil {
+			return nil, err
+		}
+
+		projection, err := projectFields(b, wanted)
+		if err != nil {
+			return nil, decodeError(collection, d.keyOf(file), err)
+		}
+		projections = append(projections, projection)
+	}
+	return projections, nil
+}
+
+// Helper function to decode the wanted top-level fields of a JSON object
+func projectFields(b []byte, wanted map[string]bool) (map[string]interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+
+	// Only objects have fields to pick from
+	if tok, err := dec.Token(); err != nil {
+		return nil, err
+	} else if tok != json.Delim('{') {
+		return nil, fmt.Errorf("record is not a JSON object")
+	}
+
+	projection := make(map[string]interface{})
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key := tok.(string)
+
+		// Decode the fields we were asked for, and step over the value of everything else
+		if wanted[key] {
+			var value interface{}
+			if err := dec.Decode(&value); err != nil {
+				return nil, err
+			}
+			projection[key] = value
+			continue
+		}
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return nil, err
+		}
+	}
+	return projection, nil
+}
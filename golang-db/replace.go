@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"           // For formatted error messages
+	"os"            // For file and directory operations
+	"path/filepath" // For file path operations
+	"sort"          // For writing records in a deterministic order
+)
+
+// Method to atomically replace the whole contents of a collection
+// All records are written into a temporary directory next to the collection first, then the old
+// directory is moved aside and the new one renamed into its place, and the old one is removed.
+// Readers see either the old collection or the complete new one, never a partial rebuild (there is
+// a brief moment between the two renames where the collection directory is absent).
+func (d *Driver) ReplaceCollection(collection string, records map[string]interface{}) error {
+	// Refuse to write when the driver was opened read-only
+	if d.readOnly {
+		return ErrReadOnly
+	}
+
+	// Validate that a collection name is provided
+	if collection == "" {
+		return fmt.Errorf("%w - unable to replace records", ErrMissingCollection)
+	}
+
+	// Hold the collection mutex so no write lands in the old directory during the swap
+	mutex, err := d.lockCollection(collection)
+	if err != nil {
+		return err
+	}
+	defer mutex.Unlock()
+
+	// Build the new collection off to the side, clearing out any leftovers of an earlier failed run
+	staging := collection + ".replace.tmp"
+	stagingDir := filepath.Join(d.dir, staging)
+	if err := os.RemoveAll(stagingDir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(stagingDir, 0755); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(records))
+	for name := range records {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, resource := range names {
+		if err := d.writeStaged(staging, resource, records[resource]); err != nil {
+			os.RemoveAll(stagingDir)
+			d.replicate(stagingDir)
+			return fmt.Errorf("unable to write %v: %w", resource, err)
+		}
+	}
+
+	// Note which fields the old collection was indexed on, so the new one gets the same indexes
+	dir := filepath.Join(d.dir, collection)
+	fields, err := listIndexes(dir)
+	if err != nil {
+		os.RemoveAll(stagingDir)
+		d.replicate(stagingDir)
+		return err
+	}
+
+	// Swap the directories: move the old collection aside, then move the new one into place
+	if err := swapDir(stagingDir, dir); err != nil {
+		os.RemoveAll(stagingDir)
+		d.replicate(stagingDir)
+		return err
+	}
+
+	// Perform the same swap on the replica, where the staged records were already mirrored
+	if d.replica != "" {
+		if err := swapDir(d.replicaPath(stagingDir), d.replicaPath(dir)); err != nil {
+			d.log.Warn("Unable to swap replica of collection '%s': %v", collection, err)
+		}
+	}
+
+	for _, field := range fields {
+		if err := d.buildIndex(dir, field); err != nil {
+			return fmt.Errorf("unable to rebuild index on %v: %w", field, err)
+		}
+	}
+	return nil
+}
+
+// Method to write a single record into a staging collection
+func (d *Driver) writeStaged(staging, resource string, v interface{}) error {
+	path, err := d.resolvePath(staging, resource)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	b, err := d.marshalRecord(v)
+	if err != nil {
+		return err
+	}
+	if err := d.writeKey(path, resource); err != nil {
+		return err
+	}
+	return d.writeRecord(path, b)
+}
+
+// Helper function to move a directory into place over an existing one
+// The existing directory (if any) is renamed aside first and removed once the swap succeeded
+func swapDir(src, dst string) error {
+	old := dst + ".replace.old"
+	if err := os.RemoveAll(old); err != nil {
+		return err
+	}
+
+	hadOld := false
+	if _, err := os.Stat(dst); err == nil {
+		if err := os.Rename(dst, old); err != nil {
+			return err
+		}
+		hadOld = true
+	}
+
+	if err := os.Rename(src, dst); err != nil {
+		// Put the old collection back so the failed replace is a no-op
+		if hadOld {
+			os.Rename(old, dst)
+		}
+		return err
+	}
+	return os.RemoveAll(old)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"        // For checking missing files
+	"io/ioutil"     // For reading primary files
+	"os"            // For file and directory operations
+	"path/filepath" // For mapping primary paths onto the replica
+	"sort"          // For reporting pending files in a stable order
+)
+
+// Struct describing how far the replica directory has drifted from the primary
+type ReplicationStatus struct {
+	Enabled bool     // Whether a replica directory is configured
+	Replica string   // Path of the replica directory
+	Pending int      // Number of files that still need to be copied to (or removed from) the replica
+	Files   []string // Paths of the pending files, relative to the database directory
+}
+
+// Method to report the replication status and lag
+func (d *Driver) ReplicationStatus() ReplicationStatus {
+	d.replicaMutex.Lock()
+	defer d.replicaMutex.Unlock()
+
+	status := ReplicationStatus{
+		Enabled: d.replica != "",
+		Replica: d.replica,
+		Pending: len(d.pending),
+	}
+	for rel := range d.pending {
+		status.Files = append(status.Files, rel)
+	}
+	sort.Strings(status.Files)
+	return status
+}
+
+// Method to retry replicating every pending file
+// It returns the number of files that are still pending afterwards
+func (d *Driver) ResyncReplica() int {
+	d.replicaMutex.Lock()
+	var paths []string
+	for rel := range d.pending {
+		paths = append(paths, filepath.Join(d.dir, rel))
+	}
+	d.replicaMutex.Unlock()
+
+	d.replicate(paths...)
+	return d.ReplicationStatus().Pending
+}
+
+// Helper function to map a path in the database directory onto the replica directory
+func (d *Driver) replicaPath(path string) string {
+	rel, err := filepath.Rel(d.dir, path)
+	if err != nil {
+		return filepath.Join(d.replica, filepath.Base(path))
+	}
+	return filepath.Join(d.replica, rel)
+}
+
+// Method to bring the replica copies of the given primary paths up to date
+// Files present on the primary are copied, missing ones are removed from the replica.
+// Replication is best effort: failures are logged and tracked as pending instead of failing the write.
+func (d *Driver) replicate(paths ...string) {
+	if d.replica == "" {
+		return
+	}
+
+	for _, path := range paths {
+		rel, err := filepath.Rel(d.dir, path)
+		if err != nil {
+			continue
+		}
+
+		err = replicateFile(path, d.replicaPath(path))
+
+		d.replicaMutex.Lock()
+		if err != nil {
+			d.pending[rel] = true
+			d.log.Warn("Unable to replicate '%s': %v", rel, err)
+		} else {
+			delete(d.pending, rel)
+		}
+		d.replicaMutex.Unlock()
+	}
+}
+
+// Helper function to copy one primary path to the replica, or remove it if it no longer exists
+func replicateFile(src, dst string) error {
+	b, err := ioutil.ReadFile(src)
+	if errors.Is(err, os.ErrNotExist) {
+		return os.RemoveAll(dst)
+	}
+	if err != nil {
+		// Directories can only be mirrored as deletions
+		if fi, serr := os.Stat(src); serr == nil && fi.IsDir() {
+			return nil
+		}
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	return writeAtomic(dst, b)
+}
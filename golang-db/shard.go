@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/sha1"   // For hashing resource names into shard prefixes
+	"encoding/hex"  // For turning the hash into directory names
+	"errors"        // For recognizing keys the encoder rejected
+	"fmt"           // For formatted error messages
+	"io/ioutil"     // For listing flat collection directories
+	"os"            // For file and directory operations
+	"path/filepath" // For file path operations
+	"strings"       // For trimming file extensions and checking encoded names
+)
+
+// Helper function to build the file path of a record from its resource name
+// The name is passed through the configured key encoder first
+func (d *Driver) resolvePath(collection, resource string) (string, error) {
+	if _, err := d.collectionKey(collection); err != nil {
+		return "", err
+	}
+	name, err := d.encodeKey(resource)
+	if err != nil {
+		if errors.Is(err, ErrUnsafeKey) {
+			return "", fmt.Errorf("%w: %w", ErrInvalidName, err)
+		}
+		return "", err
+	}
+
+	// Don't trust a custom encoder to keep the record inside its collection
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\\x00") {
+		return "", fmt.Errorf("resource %q maps to %q: %w", resource, name, ErrInvalidName)
+	}
+	return d.recordPath(collection, name), nil
+}
+
+// Helper function to build the file path of a record from its (already encoded) file name
+// With sharding enabled the record lives two levels down, under the first four hex characters of
+// the SHA-1 of its name (e.g. users/ab/cd/John Doe.json)
+func (d *Driver) recordPath(collection, name string) string {
+	if !d.sharded {
+		return filepath.Join(d.dir, collection, name+d.ext)
+	}
+	return filepath.Join(d.dir, collection, shardDir(name), name+d.ext)
+}
+
+// Helper function to find the directory of the collection a record file belongs to
+func (d *Driver) collectionRoot(record string) string {
+	dir := filepath.Dir(record)
+	if d.sharded {
+		// Step out of the two shard levels
+		dir = filepath.Dir(filepath.Dir(dir))
+	}
+	return dir
+}
+
+// Helper function to compute the shard subdirectory for a resource name
+func shardDir(resource string) string {
+	sum := sha1.Sum([]byte(resource))
+	prefix := hex.EncodeToString(sum[:2])
+	return filepath.Join(prefix[:2], prefix[2:])
+}
+
+// Helper function to report whether a directory name could be a shard level (two hex characters)
+func isShardDir(name string) bool {
+	if len(name) != 2 {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
+
+// Helper function to list the record files of a collection directory
+// Flat collections only look at the top level; sharded collections walk the whole shard tree.
+// The files are returned ordered by resource name using the configured Order (lexical by default).
+func (d *Driver) recordFiles(dir string) ([]string, error) {
+	files, err := d.listFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	d.sortFiles(files)
+	return files, nil
+}
+
+// Helper function to collect the record files of a collection directory, in directory order
+// Symlinks are followed: a symlinked collection directory is listed like a regular one, and a
+// symlinked record is listed like the file it points to. Links to directories are skipped, and a
+// dangling link is reported as an error rather than showing up as an unreadable record.
+func (d *Driver) listFiles(dir string) ([]string, error) {
+	var files []string
+
+	if !d.sharded {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			path := filepath.Join(dir, entry.Name())
+			info, err := followLink(path, entry)
+			if err != nil {
+				return nil, err
+			}
+			if info.IsDir() || isMetadataFile(entry.Name()) {
+				continue // Skip directories and metadata, as we are only interested in records
+			}
+			files = append(files, path)
+		}
+		return files, nil
+	}
+
+	// Walk does not descend into a symlinked root, so walk its target and map paths back under dir
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, err
+	}
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && path != root && !isShardDir(info.Name()) {
+			return filepath.SkipDir // Record history, indexes and nested collections are not part of the collection
+		}
+		if info, err = followLink(path, info); err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() && !isMetadataFile(info.Name()) {
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			files = append(files, filepath.Join(dir, rel))
+		}
+		return nil
+	})
+	return files, err
+}
+
+// Helper function to resolve a symlinked directory entry to the file it points to
+// Entries that are not symlinks are returned unchanged
+func followLink(path string, info os.FileInfo) (os.FileInfo, error) {
+	if info.Mode()&os.ModeSymlink == 0 {
+		return info, nil
+	}
+	target, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("broken symlink: %w", err)
+	}
+	return target, nil
+}
+
+// Method to move the records of an existing flat collection into shard directories
+// Use this once after turning on Options.Sharded for a database that was written without it.
+// It reports how many records were moved; records already in a shard directory are left alone.
+func (d *Driver) Reshard(collection string) (moved int, err error) {
+	// Refuse to write when the driver was opened read-only
+	if d.readOnly {
+		return 0, ErrReadOnly
+	}
+
+	// Resharding only makes sense when the driver reads from shard directories
+	if !d.sharded {
+		return 0, fmt.Errorf("sharding is not enabled - set Options.Sharded to reshard a collection")
+	}
+
+	// Validate that a collection name is provided
+	if collection == "" {
+		return 0, fmt.Errorf("%w - unable to reshard records", ErrMissingCollection)
+	}
+
+	// Lock the collection so no write lands in the old location while records are moved
+	mutex, err := d.lockCollection(collection)
+	if err != nil {
+		return 0, err
+	}
+	defer mutex.Unlock()
+
+	// Check if the collection directory exists
+	dir, err := d.collectionDir(collection)
+	if err != nil {
+		return 0, err
+	}
+
+	// Only the top level holds un-sharded records
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !d.isRecordFile(entry.Name()) {
+			continue
+		}
+
+		// Move the record into its shard directory, renaming keeps the move atomic
+		resource := d.resourceName(entry.Name())
+		target := d.recordPath(collection, resource)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return moved, err
+		}
+		if err := os.Rename(filepath.Join(dir, entry.Name()), target); err != nil {
+			return moved, err
+		}
+
+		d.replicate(filepath.Join(dir, entry.Name()), target)
+
+		// Bring the sidecars (checksum, original key) along, if the record has any
+		for _, sidecar := range sidecars(filepath.Join(dir, entry.Name())) {
+			if _, err := os.Stat(sidecar); err != nil {
+				continue
+			}
+			moveTo := target + strings.TrimPrefix(sidecar, filepath.Join(dir, entry.Name()))
+			if err := os.Rename(sidecar, moveTo); err != nil {
+				return moved, err
+			}
+			d.replicate(sidecar, moveTo)
+		}
+		moved++
+	}
+	return moved, nil
+}
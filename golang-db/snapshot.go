@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"           // For formatted error messages
+	"io"            // For copying file contents
+	"os"            // For file and directory operations
+	"path/filepath" // For walking and joining file paths
+	"sort"          // For locking collections in a consistent order
+	"strings"       // For skipping temporary files
+)
+
+// Method to take a point-in-time snapshot of one or more collections
+// The collections' read locks are all held while the files are copied, so the snapshot is never torn
+// by a concurrent write. Writes to those collections block for the duration of the copy; reads don't.
+// The snapshot is staged next to dst and renamed into place once complete, so dst either holds
+// the full snapshot or does not exist at all. dst must not already exist.
+func (d *Driver) Snapshot(dst string, collections ...string) error {
+	// Validate that at least one collection is requested
+	if len(collections) == 0 {
+		return fmt.Errorf("%w - nothing to snapshot", ErrMissingCollection)
+	}
+
+	// Refuse to overwrite an existing snapshot
+	dst = filepath.Clean(dst)
+	if _, err := os.Stat(dst); err == nil {
+		return fmt.Errorf("snapshot destination %v already exists", dst)
+	}
+
+	// Sort and de-duplicate the names so every caller locks in the same order, avoiding deadlocks
+	names := append([]string(nil), collections...)
+	sort.Strings(names)
+	unique := names[:0]
+	for i, name := range names {
+		if name == "" {
+			return fmt.Errorf("%w - unable to snapshot a collection with no name", ErrMissingCollection)
+		}
+		if i == 0 || name != names[i-1] {
+			unique = append(unique, name)
+		}
+	}
+
+	// Lock every collection before copying anything
+	for _, name := range unique {
+		mutex, err := d.rlockCollection(name)
+		if err != nil {
+			return err
+		}
+		defer mutex.RUnlock()
+	}
+
+	// Copy everything into a staging directory first
+	staging := dst + ".tmp"
+	if err := os.RemoveAll(staging); err != nil {
+		return err
+	}
+	for _, name := range unique {
+		if err := copyCollection(filepath.Join(d.dir, name), filepath.Join(staging, name)); err != nil {
+			os.RemoveAll(staging)
+			return err
+		}
+	}
+
+	// Move the completed snapshot into place in one step
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		os.RemoveAll(staging)
+		return err
+	}
+	if err := os.Rename(staging, dst); err != nil {
+		os.RemoveAll(staging)
+		return err
+	}
+	return nil
+}
+
+// Helper function to copy a collection directory, skipping temporary files
+// Symlinks are followed the same way listFiles follows them, so the copy holds the linked contents
+func copyCollection(src, dst string) error {
+	// Check if the collection directory exists
+	if _, err := os.Stat(src); err != nil {
+		return err
+	}
+
+	// Walk does not descend into a symlinked root, so walk its target instead
+	src, err := filepath.EvalSymlinks(src)
+	if err != nil {
+		return err
+	}
+
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info, err = followLink(path, info); err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		// Recreate directories, copy regular files and ignore half-written temp files
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, 0755)
+		case strings.HasSuffix(info.Name(), ".tmp"):
+			return nil
+		case info.Mode().IsRegular():
+			return copyFile(path, target)
+		}
+		return nil
+	})
+}
+
+// Helper function to copy a single file
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
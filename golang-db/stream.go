@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"     // For handing out decrypted records as a reader
+	"errors"    // For inspecting wrapped errors
+	"fmt"       // For formatted error messages
+	"io"        // For handing out the record as a reader
+	"io/ioutil" // For reading encrypted records in full
+	"os"        // For opening record files
+)
+
+// Method to open a single record for incremental reading, e.g. with a streaming json.Decoder
+// No lock is held while the reader is open. Writers replace records by renaming a new file over the
+// old one and Delete unlinks it, so an open reader keeps seeing the version it opened even if the
+// record is rewritten or deleted meanwhile; callers that need the latest contents must reopen.
+// Checksums are not verified on this path, as that would mean reading the whole file up front.
+// Encrypted records are the exception: they are read and decrypted in full when opened.
+// The caller must Close the reader.
+func (d *Driver) ReadStream(collection, resource string) (io.ReadCloser, error) {
+	// Validate that a collection name is provided
+	if collection == "" {
+		return nil, fmt.Errorf("%w - unable to read record", ErrMissingCollection)
+	}
+
+	// Validate that a resource name is provided
+	if resource == "" {
+		return nil, fmt.Errorf("%w - unable to read record (no name)", ErrMissingResource)
+	}
+
+	record, err := d.resolvePath(collection, resource)
+	if err != nil {
+		return nil, err
+	}
+
+	// Make sure the file really belongs to this resource and not to one that encodes the same way
+	if err := d.checkKey(record, resource); err != nil {
+		return nil, err
+	}
+
+	// Fall back to the replica copy if the primary one is missing
+	file, err := os.Open(record)
+	if errors.Is(err, os.ErrNotExist) && d.replica != "" {
+		if rfile, rerr := os.Open(d.replicaPath(record)); rerr == nil {
+			d.log.Warn("Serving '%s' from replica: %v", record, err)
+			file, err = rfile, nil
+		}
+	}
+	if err != nil {
+		return nil, d.readError(collection, resource, err)
+	}
+
+	// Refuse to stream a file that has grown past the size limit
+	if d.maxRecordBytes > 0 {
+		fi, err := file.Stat()
+		if err == nil {
+			err = d.checkStoredSize(record, fi.Size())
+		}
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	// An encrypted record can only be checked once it is read in full, so it is decrypted up front
+	if d.aead != nil {
+		defer file.Close()
+		b, err := ioutil.ReadAll(file)
+		if err != nil {
+			return nil, err
+		}
+		if b, err = d.decodeStored(record, b); err != nil {
+			return nil, err
+		}
+		if err := d.checkRecordSize(record, int64(len(b))); err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(bytes.NewReader(b)), nil
+	}
+
+	// Hand out the decompressed record, if compression is enabled
+	return d.decompressStream(file)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"        // For recognizing missing records
+	"fmt"           // For formatted error messages
+	"io/ioutil"     // For listing the trash
+	"os"            // For moving record files
+	"path/filepath" // For file path operations
+)
+
+// Name of the directory, at the top of a collection, that holds soft-deleted records
+// Listing methods skip it, so trashed records are invisible until restored
+const trashDirName = ".trash"
+
+// Method to soft-delete a record, moving it into the collection's .trash directory instead of removing it
+// The record (with its sidecars) can be brought back with Restore and listed with ListTrash.
+// Soft-deleting a resource that is already in the trash replaces the copy there.
+// Returns ErrRecordNotFound if the record doesn't exist.
+func (d *Driver) SoftDelete(collection, resource string) error {
+	// Refuse to write when the driver was opened read-only
+	if d.readOnly {
+		return ErrReadOnly
+	}
+
+	// Validate that a collection name is provided
+	if collection == "" {
+		return fmt.Errorf("%w - unable to delete record", ErrMissingCollection)
+	}
+
+	// Validate that a resource name is provided
+	if resource == "" {
+		return fmt.Errorf("%w - unable to delete record (no name)", ErrMissingResource)
+	}
+
+	record, err := d.resolvePath(collection, resource)
+	if err != nil {
+		return err
+	}
+
+	mutex, err := d.lockCollection(collection)
+	if err != nil {
+		return err
+	}
+	defer mutex.Unlock()
+
+	// Make sure the file really belongs to this resource and not to one that encodes the same way
+	if err := d.checkKey(record, resource); err != nil {
+		return err
+	}
+
+	trashed := d.trashPath(collection, record)
+	if err := os.MkdirAll(filepath.Dir(trashed), 0755); err != nil {
+		return err
+	}
+	if err := moveRecord(record, trashed); errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%v/%v: %w", collection, resource, ErrRecordNotFound)
+	} else if err != nil {
+		return err
+	}
+	d.replicate(append([]string{record, trashed}, append(sidecars(record), sidecars(trashed)...)...)...)
+
+	// Drop the record from the collection's indexes
+	return d.updateIndexes(record, resource, nil)
+}
+
+// Method to move a soft-deleted record out of the trash and back into its collection
+// Returns ErrRecordNotFound if the record isn't in the trash, and an error wrapping os.ErrExist if a
+// record with the same name has been written since, so it is never overwritten.
+func (d *Driver) Restore(collection, resource string) error {
+	// Refuse to write when the driver was opened read-only
+	if d.readOnly {
+		return ErrReadOnly
+	}
+
+	// Validate that a collection name is provided
+	if collection == "" {
+		return fmt.Errorf("%w - unable to restore record", ErrMissingCollection)
+	}
+
+	// Validate that a resource name is provided
+	if resource == "" {
+		return fmt.Errorf("%w - unable to restore record (no name)", ErrMissingResource)
+	}
+
+	record, err := d.resolvePath(collection, resource)
+	if err != nil {
+		return err
+	}
+
+	mutex, err := d.lockCollection(collection)
+	if err != nil {
+		return err
+	}
+	defer mutex.Unlock()
+
+	if _, err := os.Stat(record); err == nil {
+		return fmt.Errorf("%v/%v: %w", collection, resource, os.ErrExist)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	trashed := d.trashPath(collection, record)
+	if err := os.MkdirAll(filepath.Dir(record), 0755); err != nil {
+		return err
+	}
+	if err := moveRecord(trashed, record); errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%v/%v: %w", collection, resource, ErrRecordNotFound)
+	} else if err != nil {
+		return err
+	}
+	d.replicate(append([]string{record, trashed}, append(sidecars(record), sidecars(trashed)...)...)...)
+
+	// Put the record back into the collection's indexes
+	b, err := d.readRecord(record)
+	if err != nil {
+		return err
+	}
+	return d.updateIndexes(record, resource, b)
+}
+
+// Method to list the resource names of the soft-deleted records of a collection, in ReadAll order
+func (d *Driver) ListTrash(collection string) ([]string, error) {
+	// Validate that a collection name is provided
+	if collection == "" {
+		return nil, fmt.Errorf("%w - unable to list trash", ErrMissingCollection)
+	}
+
+	// Check if the collection directory exists
+	dir, err := d.collectionDir(collection)
+	if err != nil {
+		return nil, d.emptyIfMissing(err)
+	}
+
+	entries, err := ioutil.ReadDir(filepath.Join(dir, trashDirName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() && d.isRecordFile(entry.Name()) {
+			files = append(files, filepath.Join(dir, trashDirName, entry.Name()))
+		}
+	}
+	d.sortFiles(files)
+
+	keys := []string{}
+	for _, file := range files {
+		keys = append(keys, d.keyOf(file))
+	}
+	return keys, nil
+}
+
+// Helper function to build the path a record file is kept under while it is in the trash
+// The trash is always flat, even when the collection is sharded
+func (d *Driver) trashPath(collection, record string) string {
+	return filepath.Join(d.dir, collection, trashDirName, filepath.Base(record))
+}
+
+// Helper function to move a record file along with whichever of its sidecars exist
+// The record goes last, so a record that shows up at the destination has its sidecars with it
+func moveRecord(src, dst string) error {
+	if _, err := os.Stat(src); err != nil {
+		return err
+	}
+	for _, sidecar := range sidecars(src) {
+		err := os.Rename(sidecar, dst+sidecar[len(src):])
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return os.Rename(src, dst)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context" // For stopping the stream early
+	"errors"  // For skipping records deleted mid-stream
+	"fmt"     // For formatted error messages
+	"os"      // For recognizing deleted records
+	"reflect" // For filling a slice of any element type
+)
+
+// Method to read every record of a collection into a slice, e.g. ReadAllInto("users", &users) with users a []User
+// out must be a pointer to a slice; records are decoded into new elements in ReadAll order and the
+// slice is only replaced once all of them decoded, so out is left untouched on error. Leftover
+// temporary files are skipped.
+func (d *Driver) ReadAllInto(collection string, out interface{}) error {
+	target := reflect.ValueOf(out)
+	if target.Kind() != reflect.Pointer || target.IsNil() || target.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("ReadAllInto needs a pointer to a slice, got %T", out)
+	}
+	sliceType := target.Elem().Type()
+
+	// Validate that a collection name is provided
+	if collection == "" {
+		return fmt.Errorf("%w - unable to read records", ErrMissingCollection)
+	}
+
+	// Hold the collection's read lock, so no record is read halfway through a write
+	mutex, err := d.rlockCollection(collection)
+	if err != nil {
+		return err
+	}
+	defer mutex.RUnlock()
+
+	// Construct the directory path for the collection and check that it exists
+	dir, err := d.collectionDir(collection)
+	if err != nil {
+		if err = d.emptyIfMissing(err); err == nil {
+			target.Elem().Set(reflect.MakeSlice(sliceType, 0, 0))
+		}
+		return err
+	}
+
+	files, err := d.recordFiles(dir)
+	if err != nil {
+		return err
+	}
+
+	records := reflect.MakeSlice(sliceType, 0, len(files))
+	for _, file := range files {
+		if !d.isRecordFile(file) {
+			continue // Skip temporary files
+		}
+
+		b, err := d.readRecord(file)
+		if errors.Is(err, os.ErrNotExist) {
+			continue // Deleted since the directory was listed
+		}
+		if err != nil {
+			return err
+		}
+
+		record := reflect.New(sliceType.Elem())
+		if err := d.codec.Unmarshal(b, record.Interface()); err != nil {
+			return decodeError(collection, d.keyOf(file), err)
+		}
+		records = reflect.Append(records, record.Elem())
+	}
+
+	target.Elem().Set(records)
+	return nil
+}
+
+// Function to stream the records of a collection decoded into T, one at a time, in ReadAll order
+// Only one record is held in memory at a time, so it suits collections too big for ReadAll. Range
+// over the first channel, then receive from the second: it yields the error that stopped the
+// stream early (a DecodeError for a record that doesn't fit T, or ctx.Err() when ctx is cancelled)
+// or nothing if every record was sent. Both channels are closed once the stream ends. The stream
+// holds the collection's read lock until it ends, so writes wait for it: drain it or cancel ctx.
+func StreamTyped[T any](d *Driver, ctx context.Context, collection string) (<-chan T, <-chan error) {
+	records := make(chan T)
+	errc := make(chan error, 1) // Buffered so the stream can always finish, even if nobody reads the error
+
+	go func() {
+		defer close(errc)
+		defer close(records)
+		if err := streamTyped(d, ctx, collection, records); err != nil {
+			errc <- err
+		}
+	}()
+	return records, errc
+}
+
+// Helper function doing the work of StreamTyped, returning the error that stopped it
+func streamTyped[T any](d *Driver, ctx context.Context, collection string, records chan<- T) error {
+	// Validate that a collection name is provided
+	if collection == "" {
+		return fmt.Errorf("%w - unable to read records", ErrMissingCollection)
+	}
+
+	// Hold the collection's read lock, so no record is read halfway through a write
+	mutex, err := d.rlockCollectionContext(ctx, collection)
+	if err != nil {
+		return err
+	}
+	defer mutex.RUnlock()
+
+	// Construct the directory path for the collection and check that it exists
+	dir, err := d.collectionDir(collection)
+	if err != nil {
+		return d.emptyIfMissing(err)
+	}
+
+	files, err := d.recordFiles(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if !d.isRecordFile(file) {
+			continue // Skip temporary files
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		b, err := d.readRecord(file)
+		if errors.Is(err, os.ErrNotExist) {
+			continue // Deleted since the directory was listed
+		}
+		if err != nil {
+			return err
+		}
+
+		var record T
+		if err := d.codec.Unmarshal(b, &record); err != nil {
+			return decodeError(collection, d.keyOf(file), err)
+		}
+
+		select {
+		case records <- record:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
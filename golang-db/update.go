@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"         // For decoding numbers without losing precision
+	"encoding/json" // For editing records field by field
+	"errors"        // For inspecting wrapped errors
+	"fmt"           // For formatted error messages
+	"os"            // For detecting missing records
+)
+
+// Method to append a value to an array field of a record
+// The read, append and write all happen under the collection lock, so concurrent appends never lose
+// each other's values. A missing (or null) field is created as a new array; a field holding anything
+// other than an array is an error and leaves the record untouched.
+func (d *Driver) AppendToArray(collection, resource, field string, value interface{}) error {
+	// Refuse to write when the driver was opened read-only
+	if d.readOnly {
+		return ErrReadOnly
+	}
+
+	// Validate that a collection name is provided
+	if collection == "" {
+		return fmt.Errorf("%w - unable to update record", ErrMissingCollection)
+	}
+
+	// Validate that a resource name is provided
+	if resource == "" {
+		return fmt.Errorf("%w - unable to update record (no name)", ErrMissingResource)
+	}
+
+	// Validate that a field name is provided
+	if field == "" {
+		return fmt.Errorf("Missing Field - unable to append to record")
+	}
+
+	// Encode the value up front so a bad value fails before anything is locked
+	item, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	// Hold the collection mutex across the read and the write so no other write interleaves
+	mutex, err := d.lockCollection(collection)
+	if err != nil {
+		return err
+	}
+	defer mutex.Unlock()
+
+	record, fields, err := d.readFields(collection, resource)
+	if err != nil {
+		return err
+	}
+
+	// Start a new array when the field is absent, otherwise it must already be one
+	var items []json.RawMessage
+	if existing, ok := fields[field]; ok && string(existing) != "null" {
+		if err := json.Unmarshal(existing, &items); err != nil {
+			return fmt.Errorf("field %v of %v/%v is not an array", field, collection, resource)
+		}
+	}
+	items = append(items, item)
+
+	if fields[field], err = json.Marshal(items); err != nil {
+		return err
+	}
+	return d.writeFields(record, fields)
+}
+
+// Method to apply an RFC 7386 JSON merge patch to a record
+// Objects in the patch are merged into the record recursively, null removes a field and any other
+// value replaces it. The read, merge and write all happen under the collection lock.
+// Returns ErrRecordNotFound if the record doesn't exist.
+func (d *Driver) Patch(collection, resource string, patch []byte) error {
+	// Refuse to write when the driver was opened read-only
+	if d.readOnly {
+		return ErrReadOnly
+	}
+
+	// Validate that a collection name is provided
+	if collection == "" {
+		return fmt.Errorf("%w - unable to update record", ErrMissingCollection)
+	}
+
+	// Validate that a resource name is provided
+	if resource == "" {
+		return fmt.Errorf("%w - unable to update record (no name)", ErrMissingResource)
+	}
+
+	// Decode the patch up front so a malformed patch fails before anything is locked
+	changes, err := decodeValue(patch)
+	if err != nil {
+		return fmt.Errorf("invalid merge patch: %w", err)
+	}
+
+	// Hold the collection mutex across the read and the write so no other write interleaves
+	mutex, err := d.lockCollection(collection)
+	if err != nil {
+		return err
+	}
+	defer mutex.Unlock()
+
+	record, b, err := d.readExisting(collection, resource)
+	if err != nil {
+		return err
+	}
+
+	current, err := decodeValue(b)
+	if err != nil {
+		return decodeError(collection, resource, err)
+	}
+
+	out, err := d.marshalRecord(mergePatch(current, changes))
+	if err != nil {
+		return err
+	}
+	return d.writeRecord(record, out)
+}
+
+// Helper function to merge a decoded patch into a decoded document, as described in RFC 7386
+func mergePatch(target, patch interface{}) interface{} {
+	// A patch that isn't an object replaces the target outright
+	changes, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	// Patching a non-object starts from an empty object
+	doc, ok := target.(map[string]interface{})
+	if !ok {
+		doc = make(map[string]interface{})
+	}
+
+	for key, value := range changes {
+		if value == nil {
+			delete(doc, key)
+			continue
+		}
+		doc[key] = mergePatch(doc[key], value)
+	}
+	return doc
+}
+
+// Helper function to decode arbitrary JSON, keeping numbers exact instead of turning them into floats
+func decodeValue(b []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Helper function to read an existing record for an in-place update
+// The caller must hold the collection lock. It returns the record's path along with its contents,
+// or ErrRecordNotFound (ErrCollectionNotFound for a missing collection) if there is nothing to update.
+func (d *Driver) readExisting(collection, resource string) (string, []byte, error) {
+	record, err := d.resolvePath(collection, resource)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Make sure the file really belongs to this resource and not to one that encodes the same way
+	if err := d.checkKey(record, resource); err != nil {
+		return "", nil, err
+	}
+
+	b, err := d.readRecord(record)
+	if errors.Is(err, os.ErrNotExist) {
+		// Tell a collection that was never created apart from a record that doesn't exist
+		if _, cerr := d.collectionDir(collection); errors.Is(cerr, ErrCollectionNotFound) {
+			return "", nil, cerr
+		}
+		return "", nil, fmt.Errorf("%v/%v: %w", collection, resource, ErrRecordNotFound)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return record, b, nil
+}
+
+// Helper function to read a record as a set of top-level fields, for in-place updates
+// The caller must hold the collection lock. It returns the record's path along with its fields.
+func (d *Driver) readFields(collection, resource string) (string, map[string]json.RawMessage, error) {
+	record, b, err := d.readExisting(collection, resource)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Only JSON objects have fields to update
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return "", nil, decodeError(collection, resource, err)
+	}
+	if fields == nil {
+		fields = make(map[string]json.RawMessage)
+	}
+	return record, fields, nil
+}
+
+// Helper function to write updated fields back in the same indented format Insert uses
+func (d *Driver) writeFields(record string, fields map[string]json.RawMessage) error {
+	b, err := d.marshalRecord(fields)
+	if err != nil {
+		return err
+	}
+	return d.writeRecord(record, b)
+}
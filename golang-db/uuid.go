@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"     // For inserting without a deadline
+	"crypto/rand" // For generating random UUIDs
+	"fmt"         // For formatting UUIDs
+)
+
+// Method to insert a record under a random UUID (version 4), returning the UUID
+// Processes can insert this way without coordinating IDs. In the astronomically unlikely event
+// that the UUID is already taken, a new one is generated rather than overwriting the record.
+func (d *Driver) InsertWithUUID(collection string, v interface{}) (id string, err error) {
+	defer d.metrics.observe(&d.metrics.inserts, &err) // Count the call for MetricsSnapshot
+
+	for {
+		if id, err = newUUID(); err != nil {
+			return "", err
+		}
+		existed, err := d.insert(context.Background(), collection, id, v, false)
+		if err != nil {
+			return "", err
+		}
+		if !existed {
+			return id, nil
+		}
+	}
+}
+
+// Helper function to generate a random (version 4) UUID in its usual text form
+func newUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40 // Version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
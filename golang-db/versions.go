@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"        // For inspecting wrapped errors
+	"fmt"           // For formatted error messages
+	"io/ioutil"     // For listing and reading history files
+	"os"            // For file and directory operations
+	"path/filepath" // For file path operations
+	"sort"          // For ordering version numbers
+	"strconv"       // For naming history files by version number
+	"strings"       // For trimming file extensions
+)
+
+// Name of the directory, at the top of a collection, that holds the history of its records
+// Each record gets its own subdirectory of numbered copies (e.g. users/.versions/John Doe/3.json)
+const versionsDirName = ".versions"
+
+// Helper function to find the history directory of a record from the record's path
+func (d *Driver) versionsDir(record string) string {
+	return filepath.Join(d.collectionRoot(record), versionsDirName, d.resourceName(record))
+}
+
+// Helper function to keep a copy of a record's current contents before it is overwritten
+// The copy gets the next version number, and the oldest copies beyond Options.KeepVersions are pruned.
+// It does nothing when history is disabled or the record doesn't exist yet.
+func (d *Driver) saveVersion(record string) error {
+	if d.keepVersions <= 0 {
+		return nil
+	}
+	if _, err := os.Stat(record); errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	dir := d.versionsDir(record)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	versions, err := listVersions(dir)
+	if err != nil {
+		return err
+	}
+
+	// Copy rather than move, so the record is never missing if the following write fails
+	next := 1
+	if len(versions) > 0 {
+		next = versions[len(versions)-1] + 1
+	}
+	target := filepath.Join(dir, strconv.Itoa(next)+".json")
+	if err := copyFile(record, target); err != nil {
+		return err
+	}
+	d.replicate(target)
+
+	// Drop the oldest copies so at most KeepVersions remain
+	versions = append(versions, next)
+	for _, old := range versions[:max(len(versions)-d.keepVersions, 0)] {
+		path := filepath.Join(dir, strconv.Itoa(old)+".json")
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		d.replicate(path)
+	}
+	return nil
+}
+
+// Helper function to list the version numbers stored in a history directory, oldest first
+func listVersions(dir string) ([]int, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []int
+	for _, entry := range entries {
+		n, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), ".json"))
+		if err != nil || filepath.Ext(entry.Name()) != ".json" {
+			continue // Skip temporary and unrelated files
+		}
+		versions = append(versions, n)
+	}
+	sort.Ints(versions)
+	return versions, nil
+}
+
+// Method to list the previous versions kept for a record, oldest first
+// Versions are numbered from 1 and keep counting up as older ones are pruned.
+func (d *Driver) ListVersions(collection, resource string) ([]int, error) {
+	// Validate that a collection name is provided
+	if collection == "" {
+		return nil, fmt.Errorf("%w - unable to list versions", ErrMissingCollection)
+	}
+
+	// Validate that a resource name is provided
+	if resource == "" {
+		return nil, fmt.Errorf("%w - unable to list versions (no name)", ErrMissingResource)
+	}
+
+	record, err := d.resolvePath(collection, resource)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := listVersions(d.versionsDir(record))
+	if err != nil {
+		return nil, err
+	}
+	if versions == nil {
+		versions = []int{}
+	}
+	return versions, nil
+}
+
+// Method to read a previous version of a record, as numbered by ListVersions
+// Returns ErrRecordNotFound if that version was never kept or has been pruned.
+func (d *Driver) ReadVersion(collection, resource string, n int) ([]byte, error) {
+	// Validate that a collection name is provided
+	if collection == "" {
+		return nil, fmt.Errorf("%w - unable to read version", ErrMissingCollection)
+	}
+
+	// Validate that a resource name is provided
+	if resource == "" {
+		return nil, fmt.Errorf("%w - unable to read version (no name)", ErrMissingResource)
+	}
+
+	record, err := d.resolvePath(collection, resource)
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(d.versionsDir(record), strconv.Itoa(n)+".json")
+	b, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%v/%v version %d: %w", collection, resource, n, ErrRecordNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Versions are copies of the record file, so they are compressed and encrypted the same way
+	return d.decodeStored(path, b)
+}
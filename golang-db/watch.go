@@ -0,0 +1,177 @@
+package main
+
+import (
+	"os"            // For telling directories apart from files
+	"path/filepath" // For file path operations
+	"strings"       // For working out how deep a new directory is
+	"sync"          // For making the stop function safe to call twice
+
+	"github.com/fsnotify/fsnotify" // For filesystem change notifications
+)
+
+// Kind of change reported by Watch
+type ChangeType string
+
+const (
+	ChangeCreate ChangeType = "create" // A record that didn't exist was written
+	ChangeUpdate ChangeType = "update" // An existing record was overwritten
+	ChangeDelete ChangeType = "delete" // A record was deleted (or soft-deleted)
+)
+
+// Struct describing one change to a watched collection
+type ChangeEvent struct {
+	Resource string     // Name of the record that changed
+	Type     ChangeType // What happened to it
+}
+
+// Method to watch a collection for records being created, updated or deleted, by any process
+// Events arrive on the returned channel until the stop function is called, which closes it.
+// A write goes through a temporary file renamed over the record, so it is reported as a single
+// create or update for the record; the temporary file itself never shows up. Metadata files,
+// record history, indexes and the trash are ignored, and nested collections are not watched.
+// Events are only as reliable as the platform's notifications: a burst of changes may be
+// reported in full, coalesced, or (if the kernel queue overflows) partly lost.
+func (d *Driver) Watch(collection string) (<-chan ChangeEvent, func(), error) {
+	// Check if the collection directory exists
+	dir, err := d.collectionDir(collection)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Note the records that exist now, so a later write can be told apart as create or update
+	files, err := d.recordFiles(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	known := make(map[string]string)
+	for _, file := range files {
+		if d.isRecordFile(file) {
+			known[file] = d.keyOf(file)
+		}
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := d.watchTree(watcher, dir, 0); err != nil {
+		watcher.Close()
+		return nil, nil, err
+	}
+
+	events := make(chan ChangeEvent, 64)
+	done := make(chan struct{})
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			close(done)
+			watcher.Close()
+		})
+	}
+
+	go func() {
+		defer close(events)
+		for {
+			select {
+			case <-done:
+				return
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				d.log.Warn("Watching '%s': %v", collection, err)
+			case ev, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				for _, change := range d.changesFor(watcher, dir, ev, known) {
+					select {
+					case events <- change:
+					case <-done:
+						return
+					}
+				}
+			}
+		}
+	}()
+	return events, stop, nil
+}
+
+// Method to add a watch on a collection directory and, when sharded, on its shard directories
+// depth is how many shard levels down dir is
+func (d *Driver) watchTree(watcher *fsnotify.Watcher, dir string, depth int) error {
+	if err := watcher.Add(dir); err != nil {
+		return err
+	}
+	if !d.sharded || depth == 2 {
+		return nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && isShardDir(entry.Name()) {
+			if err := d.watchTree(watcher, filepath.Join(dir, entry.Name()), depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Method to turn a filesystem event into the changes to report, if any
+// known maps the record files that currently exist to their resource names
+func (d *Driver) changesFor(watcher *fsnotify.Watcher, root string, ev fsnotify.Event, known map[string]string) []ChangeEvent {
+	// Start watching shard directories as they are created, picking up any record that was
+	// written into one before its watch was in place
+	if d.sharded && ev.Has(fsnotify.Create) && isShardDir(filepath.Base(ev.Name)) {
+		if fi, err := os.Stat(ev.Name); err == nil && fi.IsDir() {
+			rel, err := filepath.Rel(root, ev.Name)
+			if err != nil {
+				return nil
+			}
+			depth := strings.Count(filepath.ToSlash(rel), "/") + 1
+			if depth > 2 {
+				return nil
+			}
+			if err := d.watchTree(watcher, ev.Name, depth); err != nil {
+				d.log.Warn("Watching '%s': %v", ev.Name, err)
+			}
+
+			var changes []ChangeEvent
+			filepath.Walk(ev.Name, func(path string, info os.FileInfo, err error) error {
+				if err == nil && !info.IsDir() {
+					changes = append(changes, d.changesFor(watcher, root, fsnotify.Event{Name: path, Op: fsnotify.Create}, known)...)
+				}
+				return nil
+			})
+			return changes
+		}
+	}
+
+	// Only record files count; temporary files and sidecars are noise
+	if !d.isRecordFile(ev.Name) || isMetadataFile(filepath.Base(ev.Name)) {
+		return nil
+	}
+
+	switch {
+	case ev.Has(fsnotify.Remove), ev.Has(fsnotify.Rename):
+		resource, ok := known[ev.Name]
+		if !ok {
+			return nil
+		}
+		delete(known, ev.Name)
+		return []ChangeEvent{{Resource: resource, Type: ChangeDelete}}
+	case ev.Has(fsnotify.Create), ev.Has(fsnotify.Write):
+		resource := d.keyOf(ev.Name)
+		change := ChangeCreate
+		if _, ok := known[ev.Name]; ok {
+			change = ChangeUpdate
+		}
+		known[ev.Name] = resource
+		return []ChangeEvent{{Resource: resource, Type: change}}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package main
+
+// The balancers are standalone programs, so test each one together with its own file:
+//
+//	go test leastConnection.go leastConnection_test.go
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestBackend starts a backend answering every request with its name
+func newTestBackend(t *testing.T, name string) *httptest.Server {
+	t.Helper()
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(rw, name)
+	}))
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+// captureLog collects what the standard logger prints until the test ends
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &buf
+}
+
+func TestEjectionCapLogsOnce(t *testing.T) {
+	servers := []Server{newSimpleServer("http://localhost:1"), newSimpleServer("http://localhost:2")}
+	lb := newLoadBalancer("0", servers)
+	for _, server := range servers {
+		for i := 0; i < ejectionThreshold; i++ {
+			server.RecordOutcome(true)
+		}
+	}
+	logs := captureLog(t)
+
+	for i := 0; i < 10; i++ {
+		lb.ejectOutliers()
+	}
+	if !servers[0].Ejected() || servers[1].Ejected() {
+		t.Fatalf("ejected = %v, %v, want only the first server out with half the pool capped", servers[0].Ejected(), servers[1].Ejected())
+	}
+	if n := strings.Count(logs.String(), "Not ejecting"); n != 1 {
+		t.Fatalf("logged the held back server %d times over 10 picks, want once", n)
+	}
+
+	// Once it recovers and fails again, being held back is news again
+	servers[1].RecordOutcome(false)
+	lb.ejectOutliers()
+	for i := 0; i < ejectionThreshold; i++ {
+		servers[1].RecordOutcome(true)
+	}
+	lb.ejectOutliers()
+	if n := strings.Count(logs.String(), "Not ejecting"); n != 2 {
+		t.Fatalf("logged the held back server %d times after it failed again, want 2", n)
+	}
+}
+
+func TestEjectionBackoffIsForgiven(t *testing.T) {
+	server := newSimpleServer("http://localhost:1")
+	if got := server.Eject(); got != baseEjectionTime {
+		t.Fatalf("first ejection = %s, want %s", got, baseEjectionTime)
+	}
+	if got := server.Eject(); got != 2*baseEjectionTime {
+		t.Fatalf("repeat ejection = %s, want %s", got, 2*baseEjectionTime)
+	}
+
+	// Back in the pool and serving well for longer than the longest ejection
+	server.mutex.Lock()
+	server.ejectedUntil = time.Now().Add(-maxEjectionTime)
+	server.mutex.Unlock()
+	server.RecordOutcome(false)
+
+	server.mutex.Lock()
+	ejections := server.ejections
+	server.mutex.Unlock()
+	if ejections != 0 {
+		t.Fatalf("ejections = %d after the server recovered, want 0", ejections)
+	}
+	if got := server.Eject(); got != baseEjectionTime {
+		t.Fatalf("ejection after recovering = %s, want %s", got, baseEjectionTime)
+	}
+}
+
+func TestSplitLongLived(t *testing.T) {
+	var servers []Server
+	for _, name := range []string{"A", "B", "C"} {
+		servers = append(servers, newSimpleServer(newTestBackend(t, name).URL))
+	}
+	lb := newLoadBalancer("0", servers)
+	lb.splitLongLived = true
+
+	// A already holds a few WebSockets, which count as open connections too
+	a := servers[0].(*simpleServer)
+	for i := 0; i < 4; i++ {
+		a.addLongLived(1)
+		a.IncrementConnection()
+	}
+
+	upgrade := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	upgrade.Header.Set("Connection", "Upgrade")
+	upgrade.Header.Set("Upgrade", "websocket")
+	short := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	// New WebSockets go where the fewest are held, and stay open
+	for i := 0; i < 4; i++ {
+		server := lb.pickServer(upgrade)
+		if server == servers[0] {
+			t.Fatalf("upgrade %d went to %s, which holds the most long-lived connections", i, server.Address())
+		}
+		server.(*simpleServer).addLongLived(1)
+	}
+	if servers[1].LongLived() != 2 || servers[2].LongLived() != 2 {
+		t.Fatalf("long-lived connections = %d, %d on the other servers, want 2 each", servers[1].LongLived(), servers[2].LongLived())
+	}
+
+	// Short requests still spread evenly, A included
+	picks := map[Server]int{}
+	for i := 0; i < 30; i++ {
+		picks[lb.pickServer(short)]++
+	}
+	for _, server := range servers {
+		if picks[server] != 10 {
+			t.Fatalf("%s took %d of 30 short requests, want 10 each", server.Address(), picks[server])
+		}
+	}
+}
+
+func TestTieBreaking(t *testing.T) {
+	var servers []Server
+	for _, name := range []string{"A", "B", "C"} {
+		servers = append(servers, newSimpleServer(newTestBackend(t, name).URL))
+	}
+	lb := newLoadBalancer("0", servers)
+
+	// Without a tie breaker the first server in list order always wins
+	for i := 0; i < 5; i++ {
+		if server := lb.pickTie(servers); server != servers[0] {
+			t.Fatalf("tie %d went to %s, want the first server", i, server.Address())
+		}
+	}
+
+	// Idle servers all tie on connections, so each breaker has to share the traffic between them
+	for name, breakTie := range map[string]tieBreaker{"random": randomTie, "round robin": newRoundRobinTie()} {
+		lb.breakTie = breakTie
+		picks := map[Server]int{}
+		for i := 0; i < 300; i++ {
+			picks[lb.pickServer(httptest.NewRequest(http.MethodGet, "/", nil))]++
+		}
+		for _, server := range servers {
+			if picks[server] < 50 {
+				t.Errorf("%s tie breaking: %s took %d of 300 requests, want its share", name, server.Address(), picks[server])
+			}
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+// The balancers are standalone programs, so test each one together with its own file:
+//
+//	go test leastResponseTime.go leastResponseTime_test.go
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestBackend starts a backend answering every request with its name
+func newTestBackend(t *testing.T, name string) *httptest.Server {
+	t.Helper()
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(rw, name)
+	}))
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+func TestColdStartFallsBackToRoundRobin(t *testing.T) {
+	var servers []Server
+	for _, name := range []string{"A", "B", "C"} {
+		servers = append(servers, newSimpleServer(newTestBackend(t, name).URL))
+	}
+	lb := newLoadBalancer("0", servers)
+
+	// No backend has served a request yet, so there are no response times to compare
+	picks := map[Server]int{}
+	for i := 0; i < 9; i++ {
+		server := lb.pickServer()
+		if server == nil {
+			t.Fatalf("pick %d = nil before any response times were recorded", i)
+		}
+		picks[server]++
+	}
+	for _, server := range servers {
+		if picks[server] != 3 {
+			t.Fatalf("%s picked %d times out of 9, want 3 each while cold", server.Address(), picks[server])
+		}
+	}
+
+	// Once every backend has a response time, the fastest one wins
+	for i, server := range servers {
+		server.UpdateResponseTime(time.Duration(3-i) * time.Millisecond)
+	}
+	for i := 0; i < 5; i++ {
+		if server := lb.pickServer(); server != servers[2] {
+			t.Fatalf("pick %d went to %v, want the fastest server %s", i, server, servers[2].Address())
+		}
+	}
+}
+
+func TestErrorRateWeighted(t *testing.T) {
+	failing, healthy := newSimpleServer("http://localhost:1"), newSimpleServer("http://localhost:2")
+	for i := 0; i < errorWindow; i++ {
+		failing.RecordOutcome(true)
+		healthy.RecordOutcome(false)
+	}
+
+	picks := map[Server]int{}
+	for i := 0; i < 1000; i++ {
+		picks[errorRateWeighted([]Server{failing, healthy})]++
+	}
+
+	// Failing every request leaves it the minimum share, about 5%, but never nothing
+	if picks[failing] == 0 || picks[failing] > 150 {
+		t.Fatalf("failing server picked %d times out of 1000, want a small but nonzero share", picks[failing])
+	}
+	if picks[healthy] < 850 {
+		t.Fatalf("healthy server picked %d times out of 1000, want the bulk of the traffic", picks[healthy])
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+// The balancers are standalone programs, so test each one together with its own file:
+//
+//	go test roundRobin.go roundRobin_test.go
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testBackend is a backend whose health checks and proxied requests can be steered by a test
+type testBackend struct {
+	*httptest.Server
+	name     string
+	draining atomic.Bool  // Answer health checks with 503 and X-Draining: true
+	down     atomic.Bool  // Fail health checks with 500
+	broken   atomic.Bool  // Pass health checks but drop proxied requests without answering
+	served   atomic.Int64 // Proxied requests handled, health checks aside
+}
+
+// newTestBackend starts a backend answering health checks on / and every other path with its name
+func newTestBackend(t *testing.T, name string) *testBackend {
+	t.Helper()
+	backend := &testBackend{name: name}
+	backend.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/" {
+			if backend.draining.Load() {
+				rw.Header().Set("X-Draining", "true")
+				rw.WriteHeader(http.StatusServiceUnavailable)
+			}
+			if backend.down.Load() {
+				rw.WriteHeader(http.StatusInternalServerError)
+			}
+			return
+		}
+		backend.served.Add(1)
+		if backend.broken.Load() {
+			if conn, _, err := http.NewResponseController(rw).Hijack(); err == nil {
+				conn.Close()
+			}
+			return
+		}
+		fmt.Fprint(rw, name)
+	}))
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+// get proxies a request for path through lb and returns the response
+func get(lb *loadBalancer, path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	lb.serveProxy(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec
+}
+
+func TestBackendAnnouncesDraining(t *testing.T) {
+	a, b := newTestBackend(t, "A"), newTestBackend(t, "B")
+	lb := newLoadBalancer("0", []Server{newSimpleServer(a.URL), newSimpleServer(b.URL)})
+
+	for i := 0; i < 4; i++ {
+		get(lb, "/work")
+	}
+	if a.served.Load() == 0 || b.served.Load() == 0 {
+		t.Fatalf("served %d and %d before draining, want both used", a.served.Load(), b.served.Load())
+	}
+
+	// A is about to restart and says so on its health check
+	a.draining.Store(true)
+	before := a.served.Load()
+	for i := 0; i < 10; i++ {
+		if rec := get(lb, "/work"); rec.Code != http.StatusOK || rec.Body.String() != "B" {
+			t.Fatalf("request %d while A drains: status %d from %q, want 200 from B", i, rec.Code, rec.Body.String())
+		}
+	}
+	if got := a.served.Load(); got != before {
+		t.Fatalf("A took %d new requests after announcing it was draining", got-before)
+	}
+}
+
+func TestAdminDrain(t *testing.T) {
+	a, b := newTestBackend(t, "A"), newTestBackend(t, "B")
+	lb := newLoadBalancer("0", []Server{newSimpleServer(a.URL), newSimpleServer(b.URL)})
+
+	drain := func(enabled bool) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		lb.handleDrain(rec, httptest.NewRequest(http.MethodPost, fmt.Sprintf("/drain?backend=%s&enabled=%t", a.URL, enabled), nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("POST /drain enabled=%t: status %d: %s", enabled, rec.Code, rec.Body.String())
+		}
+	}
+
+	drain(true)
+	for i := 0; i < 10; i++ {
+		get(lb, "/work")
+	}
+	if a.served.Load() != 0 || b.served.Load() != 10 {
+		t.Fatalf("served %d and %d with A drained, want 0 and 10", a.served.Load(), b.served.Load())
+	}
+
+	drain(false)
+	for i := 0; i < 10; i++ {
+		get(lb, "/work")
+	}
+	if a.served.Load() == 0 {
+		t.Fatal("A took no requests after being put back")
+	}
+}
+
+// newStallingBackend starts a backend that never answers /slow, reporting on aborted when the
+// proxied request is cancelled
+func newStallingBackend(t *testing.T) (*httptest.Server, <-chan struct{}, <-chan struct{}) {
+	t.Helper()
+	started, aborted := make(chan struct{}, 1), make(chan struct{}, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/slow" {
+			return
+		}
+		notify(started)
+		select {
+		case <-req.Context().Done():
+			notify(aborted)
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	t.Cleanup(backend.Close)
+	return backend, started, aborted
+}
+
+// notify signals on ch without waiting, when nobody is listening anymore
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+func TestClientCancelAbortsUpstream(t *testing.T) {
+	backend, started, aborted := newStallingBackend(t)
+	lb := newLoadBalancer("0", []Server{newSimpleServer(backend.URL)})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		lb.serveProxy(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil).WithContext(ctx))
+	}()
+
+	<-started
+	cancel()
+	select {
+	case <-aborted:
+	case <-time.After(2 * time.Second):
+		t.Fatal("upstream request still running after the client went away")
+	}
+	<-done
+}
+
+func TestUpstreamTimeout(t *testing.T) {
+	backend, _, aborted := newStallingBackend(t)
+	lb := newLoadBalancer("0", []Server{newSimpleServer(backend.URL)})
+	lb.upstreamTimeout = 100 * time.Millisecond
+
+	start := time.Now()
+	rec := get(lb, "/slow")
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("status = %d, want %d once the upstream timeout passed", rec.Code, http.StatusBadGateway)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("request took %s with a 100ms upstream timeout", elapsed)
+	}
+	select {
+	case <-aborted:
+	case <-time.After(2 * time.Second):
+		t.Fatal("upstream request still running after the upstream timeout")
+	}
+
+	// A client deadline earlier than the upstream timeout wins
+	lb.upstreamTimeout = time.Minute
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	lb.serveProxy(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil).WithContext(ctx))
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("request took %s with a 100ms client deadline", elapsed)
+	}
+}
+
+func TestPickTimeoutWaitsForRecovery(t *testing.T) {
+	a := newTestBackend(t, "A")
+	a.down.Store(true)
+	lb := newLoadBalancer("0", []Server{newSimpleServer(a.URL)})
+
+	// Without a pick timeout the request fails straight away
+	if rec := get(lb, "/work"); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d with the only backend down, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	// With one, a backend coming back while the request waits still takes it
+	lb.pickTimeout = 2 * time.Second
+	time.AfterFunc(150*time.Millisecond, func() { a.down.Store(false) })
+	start := time.Now()
+	if rec := get(lb, "/work"); rec.Code != http.StatusOK || rec.Body.String() != "A" {
+		t.Fatalf("status %d from %q, want 200 from A once it recovered", rec.Code, rec.Body.String())
+	}
+	if elapsed := time.Since(start); elapsed > lb.pickTimeout {
+		t.Fatalf("request took %s, longer than the %s pick timeout", elapsed, lb.pickTimeout)
+	}
+}
+
+func TestPassiveHealthSkipsFailedBackend(t *testing.T) {
+	a, b := newTestBackend(t, "A"), newTestBackend(t, "B")
+	a.broken.Store(true)
+	servers := []Server{newSimpleServer(a.URL), newSimpleServer(b.URL)}
+	for _, server := range servers {
+		server.(*simpleServer).enablePassiveHealth()
+	}
+	lb := newLoadBalancer("0", servers)
+
+	// A looks healthy to its health check, so it takes the first request and drops it
+	if rec := get(lb, "/work"); rec.Code != http.StatusBadGateway {
+		t.Fatalf("status = %d from the broken backend, want %d", rec.Code, http.StatusBadGateway)
+	}
+
+	// From then on it is skipped, without waiting for a health check to notice
+	before := a.served.Load()
+	for i := 0; i < 10; i++ {
+		if rec := get(lb, "/work"); rec.Code != http.StatusOK || rec.Body.String() != "B" {
+			t.Fatalf("request %d after A failed: status %d from %q, want 200 from B", i, rec.Code, rec.Body.String())
+		}
+	}
+	if got := a.served.Load(); got != before {
+		t.Fatalf("A was sent %d more requests after it failed one", got-before)
+	}
+}
+
+func TestMirrorSkipsLargeBodies(t *testing.T) {
+	// The primary answers with how much body it got, the mirror reports each body it receives
+	primary := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		n, _ := io.Copy(io.Discard, req.Body)
+		fmt.Fprint(rw, n)
+	}))
+	t.Cleanup(primary.Close)
+	mirrored := make(chan int, 10)
+	mirror := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		b, _ := io.ReadAll(req.Body)
+		mirrored <- len(b)
+	}))
+	t.Cleanup(mirror.Close)
+
+	lb := newLoadBalancer("0", []Server{newSimpleServer(primary.URL)})
+	lb.addMirror(mirror.URL)
+
+	post := func(size int) string {
+		t.Helper()
+		// No Content-Length, so the size only shows once the body is read
+		req := httptest.NewRequest(http.MethodPost, "/upload", io.NopCloser(strings.NewReader(strings.Repeat("x", size))))
+		req.ContentLength = -1
+		rec := httptest.NewRecorder()
+		lb.serveProxy(rec, req)
+		return rec.Body.String()
+	}
+
+	if got := post(100); got != "100" {
+		t.Fatalf("primary got %s bytes of a 100 byte body", got)
+	}
+	select {
+	case n := <-mirrored:
+		if n != 100 {
+			t.Fatalf("mirror got %d bytes of a 100 byte body", n)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("small request was not mirrored")
+	}
+
+	// Over the limit the primary still gets the whole body, and the mirror nothing
+	if got, want := post(maxMirrorBody+10), fmt.Sprint(maxMirrorBody+10); got != want {
+		t.Fatalf("primary got %s bytes of a %s byte body", got, want)
+	}
+	select {
+	case n := <-mirrored:
+		t.Fatalf("mirror got a %d byte body over the %d byte limit", n, maxMirrorBody)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
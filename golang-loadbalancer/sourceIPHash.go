@@ -1,36 +1,73 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"crypto/md5"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"html/template"
+	"io"
 	"log"
+	"math"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Server interface {
 	Address() string
 	IsAlive() bool
+	IsDraining() bool
+	SetDraining(draining bool)
+	OnHealthChange(notify func(HealthEvent))
+	CertExpiry() (time.Time, bool)
+	RequestBytes() uint64
+	ResponseBytes() uint64
+	ResetStats()
 	Serve(rw http.ResponseWriter, req *http.Request)
 }
 
 type simpleServer struct {
-	addr  string
-	proxy *httputil.ReverseProxy
+	addr              string
+	proxy             *httputil.ReverseProxy
+	transport         http.RoundTripper
+	draining          atomic.Bool
+	healthMutex       sync.Mutex
+	checked           bool
+	healthy           bool
+	failures          int
+	nextProbe         time.Time
+	onHealthChange    func(HealthEvent)
+	certExpiry        time.Time
+	certWarned        bool
+	certExpiryWarning time.Duration
+	failOnCertExpiry  bool
+	requestBytes      atomic.Uint64
+	responseBytes     atomic.Uint64
 }
 
 func newSimpleServer(addr string) *simpleServer {
 	serveUrl, err := url.Parse(addr)
 	handleErr(err)
 
-	return &simpleServer{
+	server := &simpleServer{
 		addr:  addr,
 		proxy: httputil.NewSingleHostReverseProxy(serveUrl),
 	}
+	server.countBytes()
+	return server
 }
 
 func handleErr(err error) {
@@ -40,15 +77,64 @@ func handleErr(err error) {
 }
 
 type loadBalancer struct {
-	port    string
-	servers []Server
+	port                string
+	servers             []Server
+	mirrors             []*url.URL
+	maintenance         atomic.Bool
+	maintenancePage     string
+	errorPage           *template.Template
+	errorJSON           func(status int) interface{}
+	coalesce            bool
+	flightsMutex        sync.Mutex
+	flights             map[string]*flight
+	slowThreshold       time.Duration
+	slowLog             *log.Logger
+	selectionsMutex     sync.Mutex
+	selections          map[string]int
+	healthEvents        chan HealthEvent
+	admission           chan struct{}
+	maxQueue            int64
+	queueTimeout        time.Duration
+	queueDepth          atomic.Int64
+	allowTargetOverride bool
+	upstreamTimeout     time.Duration
+	pickTimeout         time.Duration
+	affinityHeader      string
+	remapped            atomic.Int64
+	stickyRepin         bool
+	repinHeader         string
+	pinsMutex           sync.Mutex
+	pins                map[string]pin
 }
 
+// A pin keeps a remapped client on its new slot until it has been idle for pinTTL
+type pin struct {
+	slot    int
+	expires time.Time
+}
+
+// How long an idle client stays pinned, and how many clients can be pinned at once
+const (
+	pinTTL  = 30 * time.Minute
+	maxPins = 100000
+)
+
 func newLoadBalancer(port string, servers []Server) *loadBalancer {
-	return &loadBalancer{
-		port:    port,
-		servers: servers,
+	lb := &loadBalancer{
+		port:            port,
+		servers:         servers,
+		maintenancePage: defaultMaintenancePage,
+		errorPage:       template.Must(template.New("error").Parse(defaultErrorPage)),
+		errorJSON:       defaultErrorJSON,
+		flights:         make(map[string]*flight),
+		selections:      make(map[string]int),
+		healthEvents:    make(chan HealthEvent, healthEventBuffer),
+		pins:            make(map[string]pin),
+	}
+	for _, server := range servers {
+		server.OnHealthChange(lb.publishHealth)
 	}
+	return lb
 }
 
 func (s *simpleServer) Address() string {
@@ -56,48 +142,1050 @@ func (s *simpleServer) Address() string {
 }
 
 func (s *simpleServer) IsAlive() bool {
-	// Check if the server is alive by making a simple GET request
+	// A draining server finishes its in-flight requests but takes no new ones
+	if s.IsDraining() {
+		return false
+	}
+
+	// A server that keeps failing is only probed again once its backoff has passed
+	if s.backingOff() {
+		return false
+	}
+
+	alive, err := s.check()
+	s.recordHealth(alive, err)
+	return alive
+}
+
+// check makes a simple GET request to the server, returning why it failed if it isn't healthy
+func (s *simpleServer) check() (bool, error) {
 	timeout := 2 * time.Second
 	client := http.Client{
-		Timeout: timeout,
+		Timeout:   timeout,
+		Transport: s.transport,
 	}
 
 	resp, err := client.Get(s.addr)
-	if err != nil || resp.StatusCode != http.StatusOK {
-		return false
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+
+	if err := s.checkCertExpiry(resp); err != nil {
+		return false, err
+	}
+
+	// A backend about to restart announces it with 503 and X-Draining: true
+	if resp.StatusCode == http.StatusServiceUnavailable && resp.Header.Get("X-Draining") == "true" {
+		log.Printf("Server %s announced it is draining", s.addr)
+		return false, fmt.Errorf("backend announced it is draining")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("health check returned status %d", resp.StatusCode)
+	}
+	return true, nil
+}
+
+// Delay before re-probing a server after its first failed check, doubled on every further failure
+const (
+	probeBackoff    = 500 * time.Millisecond
+	maxProbeBackoff = 30 * time.Second
+)
+
+func (s *simpleServer) backingOff() bool {
+	s.healthMutex.Lock()
+	defer s.healthMutex.Unlock()
+	return s.failures > 0 && time.Now().Before(s.nextProbe)
+}
+
+// recordHealth remembers the outcome of a check and reports it if the server went up or down
+func (s *simpleServer) recordHealth(alive bool, err error) {
+	s.healthMutex.Lock()
+	changed := s.checked && s.healthy != alive
+	s.checked, s.healthy = true, alive
+	if alive {
+		s.failures = 0
+	} else {
+		// Wait twice as long before each new probe of a failing server, up to a cap
+		s.failures++
+		backoff := min(probeBackoff<<min(s.failures-1, 16), maxProbeBackoff)
+		s.nextProbe = time.Now().Add(backoff)
+	}
+	notify := s.onHealthChange
+	s.healthMutex.Unlock()
+
+	if changed && notify != nil {
+		notify(HealthEvent{Address: s.addr, WasAlive: !alive, Alive: alive, Time: time.Now(), Err: err})
+	}
+}
+
+func (s *simpleServer) OnHealthChange(notify func(HealthEvent)) {
+	s.healthMutex.Lock()
+	defer s.healthMutex.Unlock()
+	s.onHealthChange = notify
+}
+
+func (s *simpleServer) IsDraining() bool {
+	return s.draining.Load()
+}
+
+func (s *simpleServer) SetDraining(draining bool) {
+	s.draining.Store(draining)
+}
+
+// HealthEvent reports a backend going up or down, as seen by its health checks
+type HealthEvent struct {
+	Address  string
+	WasAlive bool
+	Alive    bool
+	Time     time.Time
+	Err      error // Why the check failed, nil when the backend came back up
+}
+
+// Number of health events kept for a slow consumer before the oldest are dropped
+const healthEventBuffer = 64
+
+// HealthEvents delivers backend up/down transitions
+// The channel is buffered; if nobody keeps up with it the oldest events are dropped, so health checks
+// never wait on a subscriber
+func (lb *loadBalancer) HealthEvents() <-chan HealthEvent {
+	return lb.healthEvents
+}
+
+func (lb *loadBalancer) publishHealth(event HealthEvent) {
+	log.Printf("Server %s is now alive=%t (was %t)", event.Address, event.Alive, event.WasAlive)
+	for {
+		select {
+		case lb.healthEvents <- event:
+			return
+		default:
+		}
+
+		// The buffer is full, make room by dropping the oldest event
+		select {
+		case <-lb.healthEvents:
+		default:
+		}
+	}
+}
+
+// headerRules adjusts the headers exchanged with one backend
+type headerRules struct {
+	SetRequest     map[string]string // Added to requests, a "Host" entry overrides the Host header
+	RemoveRequest  []string
+	SetResponse    map[string]string // Added to responses before they reach the client
+	RemoveResponse []string
+}
+
+// rewriteHeaders applies rules to every request proxied to this backend and every response from it
+func (s *simpleServer) rewriteHeaders(rules headerRules) {
+	director := s.proxy.Director
+	s.proxy.Director = func(req *http.Request) {
+		director(req)
+		for _, name := range rules.RemoveRequest {
+			req.Header.Del(name)
+		}
+		for name, value := range rules.SetRequest {
+			if http.CanonicalHeaderKey(name) == "Host" {
+				req.Host = value
+				continue
+			}
+			req.Header.Set(name, value)
+		}
+	}
+
+	modifyResponse := s.proxy.ModifyResponse
+	s.proxy.ModifyResponse = func(resp *http.Response) error {
+		for _, name := range rules.RemoveResponse {
+			resp.Header.Del(name)
+		}
+		for name, value := range rules.SetResponse {
+			resp.Header.Set(name, value)
+		}
+		if modifyResponse != nil {
+			return modifyResponse(resp)
+		}
+		return nil
+	}
+}
+
+// upstreamTLS describes how to connect to a backend served over HTTPS
+type upstreamTLS struct {
+	CAFile             string // PEM bundle to trust instead of the system roots
+	CertFile           string // Client certificate for mTLS, together with KeyFile
+	KeyFile            string
+	InsecureSkipVerify bool // Skip certificate verification entirely, for testing only
+}
+
+// configureTLS applies options to both the proxied requests and the health checks of this backend
+func (s *simpleServer) configureTLS(options upstreamTLS) error {
+	config := &tls.Config{InsecureSkipVerify: options.InsecureSkipVerify}
+
+	if options.CAFile != "" {
+		pem, err := os.ReadFile(options.CAFile)
+		if err != nil {
+			return err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", options.CAFile)
+		}
 	}
-	return true
+
+	if options.CertFile != "" || options.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(options.CertFile, options.KeyFile)
+		if err != nil {
+			return err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	s.transport = transport
+	s.proxy.Transport = transport
+	return nil
+}
+
+// checkCertExpiry notes when an HTTPS backend's certificate expires and warns once per certificate when
+// that is less than certExpiryWarning away; with failOnCertExpiry the backend is also reported unhealthy
+func (s *simpleServer) checkCertExpiry(resp *http.Response) error {
+	if resp.TLS == nil || len(resp.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	notAfter := resp.TLS.PeerCertificates[0].NotAfter
+	expiring := s.certExpiryWarning > 0 && time.Until(notAfter) < s.certExpiryWarning
+
+	s.healthMutex.Lock()
+	if !notAfter.Equal(s.certExpiry) {
+		s.certExpiry = notAfter
+		s.certWarned = false
+	}
+	warn := expiring && !s.certWarned
+	if expiring {
+		s.certWarned = true
+	}
+	s.healthMutex.Unlock()
+
+	if warn {
+		log.Printf("Certificate of %s expires at %s", s.addr, notAfter.Format(time.RFC3339))
+	}
+	if expiring && s.failOnCertExpiry {
+		return fmt.Errorf("certificate expires at %s", notAfter.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// CertExpiry returns when the certificate seen by the last health check expires, if the backend uses HTTPS
+func (s *simpleServer) CertExpiry() (time.Time, bool) {
+	s.healthMutex.Lock()
+	defer s.healthMutex.Unlock()
+	return s.certExpiry, !s.certExpiry.IsZero()
+}
+
+// certExpiresInDays reports the whole days left on a backend's certificate, or nil if it has none
+func certExpiresInDays(server Server) *int {
+	expiry, ok := server.CertExpiry()
+	if !ok {
+		return nil
+	}
+	days := int(time.Until(expiry).Hours() / 24)
+	return &days
+}
+
+// countingReader adds the bytes read through it to a counter
+type countingReader struct {
+	io.ReadCloser
+	count *atomic.Uint64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.count.Add(uint64(n))
+	return n, err
+}
+
+// countBytes counts response bodies as the proxy streams them back to clients
+// Upgraded connections are left alone, the proxy needs their body to stay writable
+func (s *simpleServer) countBytes() {
+	modifyResponse := s.proxy.ModifyResponse
+	s.proxy.ModifyResponse = func(resp *http.Response) error {
+		if resp.StatusCode != http.StatusSwitchingProtocols {
+			resp.Body = &countingReader{ReadCloser: resp.Body, count: &s.responseBytes}
+		}
+		if modifyResponse != nil {
+			return modifyResponse(resp)
+		}
+		return nil
+	}
+}
+
+// RequestBytes is how many request body bytes were forwarded to this backend
+func (s *simpleServer) RequestBytes() uint64 {
+	return s.requestBytes.Load()
+}
+
+// ResponseBytes is how many response body bytes this backend sent back
+func (s *simpleServer) ResponseBytes() uint64 {
+	return s.responseBytes.Load()
+}
+
+// enablePassiveHealth treats a failed connection to this backend like a failed health check, so it is
+// skipped from the next pick on instead of after the next probe; the probe once the backoff has passed
+// confirms or clears it. Only network errors and connections dropped mid-request count, not timeouts or
+// clients going away.
+func (s *simpleServer) enablePassiveHealth() {
+	errorHandler := s.proxy.ErrorHandler
+	s.proxy.ErrorHandler = func(rw http.ResponseWriter, req *http.Request, err error) {
+		var netErr *net.OpError
+		if errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			log.Printf("Marking %s as down after a failed request: %v", s.addr, err)
+			s.recordHealth(false, err)
+		}
+		if errorHandler != nil {
+			errorHandler(rw, req, err)
+			return
+		}
+		log.Printf("Error proxying request to %s: %v", s.addr, err)
+		rw.WriteHeader(http.StatusBadGateway)
+	}
+}
+
+func (s *simpleServer) ResetStats() {
+	s.requestBytes.Store(0)
+	s.responseBytes.Store(0)
 }
 
 func (s *simpleServer) Serve(rw http.ResponseWriter, req *http.Request) {
+	// Count the request body as the proxy streams it upstream
+	if req.Body != nil && req.Body != http.NoBody {
+		req.Body = &countingReader{ReadCloser: req.Body, count: &s.requestBytes}
+	}
 	s.proxy.ServeHTTP(rw, req)
 }
 
-func hashIP(ip string) uint32 {
-	hash := md5.Sum([]byte(ip))
+func hashKey(key string) uint32 {
+	hash := md5.Sum([]byte(key))
 	return binary.BigEndian.Uint32(hash[:])
 }
 
-func (lb *loadBalancer) pickServer(ip string) Server {
-	serverIndex := int(hashIP(ip)) % len(lb.servers)
-	for !lb.servers[serverIndex].IsAlive() {
-		serverIndex = (serverIndex + 1) % len(lb.servers)
+// pickServer maps key to its home slot, moving to the next live slot only while the home server is down
+// The slots never change, so a failing server only remaps its own clients and they return once it recovers.
+// With stickyRepin a remapped client is pinned to its new slot instead, and stays there even after the
+// home server recovers, until that server fails in turn. It also reports whether the key was remapped.
+func (lb *loadBalancer) pickServer(key string) (Server, bool) {
+	home := lb.slot(key)
+	for step := 0; step < len(lb.servers); step++ {
+		slot := (home + step) % len(lb.servers)
+		server := lb.servers[slot]
+		if !server.IsAlive() {
+			continue
+		}
+		if step > 0 {
+			lb.remapped.Add(1)
+			log.Printf("Server %s is down, remapping to %s", lb.servers[home].Address(), server.Address())
+			if lb.stickyRepin {
+				lb.pin(key, slot)
+			}
+		}
+		return server, step > 0
+	}
+
+	// All servers down, return nil
+	log.Println("All servers are down")
+	return nil, false
+}
+
+// slot returns where key starts looking for a server: the slot it was pinned to, or its home slot
+func (lb *loadBalancer) slot(key string) int {
+	lb.pinsMutex.Lock()
+	defer lb.pinsMutex.Unlock()
+	if p, ok := lb.pins[key]; ok {
+		if time.Now().Before(p.expires) {
+			// Every request of a pinned client keeps its pin alive
+			lb.pins[key] = pin{slot: p.slot, expires: time.Now().Add(pinTTL)}
+			return p.slot
+		}
+		delete(lb.pins, key)
 	}
-	return lb.servers[serverIndex]
+	return int(hashKey(key) % uint32(len(lb.servers)))
+}
+
+// pin moves key to slot; when maxPins clients are pinned, expired pins are swept first, and if
+// none has expired the client is simply not pinned and keeps being remapped from its home slot
+func (lb *loadBalancer) pin(key string, slot int) {
+	lb.pinsMutex.Lock()
+	defer lb.pinsMutex.Unlock()
+	if _, ok := lb.pins[key]; !ok && len(lb.pins) >= maxPins {
+		now := time.Now()
+		for k, p := range lb.pins {
+			if !now.Before(p.expires) {
+				delete(lb.pins, k)
+			}
+		}
+		if len(lb.pins) >= maxPins {
+			return
+		}
+	}
+	lb.pins[key] = pin{slot: slot, expires: time.Now().Add(pinTTL)}
 }
 
 func (lb *loadBalancer) serveProxy(rw http.ResponseWriter, req *http.Request) {
+	if lb.maintenance.Load() {
+		lb.serveMaintenance(rw)
+		return
+	}
+
+	if !lb.admit(rw, req) {
+		return
+	}
+	defer lb.release()
+
+	lb.mirrorRequest(req)
+
+	if lb.coalesce && canCoalesce(req) {
+		lb.serveCoalesced(rw, req)
+		return
+	}
+	lb.forward(rw, req)
+}
+
+func (lb *loadBalancer) forward(rw http.ResponseWriter, req *http.Request) {
+	// Hash the client's address without its port, which changes with every connection
 	ip := req.RemoteAddr
-	targetServer := lb.pickServer(ip)
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		ip = host
+	}
+
+	// Requests carrying the affinity header (e.g. X-Tenant-ID) stick to a backend by its value instead
+	key := ip
+	if lb.affinityHeader != "" {
+		if value := req.Header.Get(lb.affinityHeader); value != "" {
+			key = value
+		}
+	}
+
+	var remapped bool
+	pick := func() Server {
+		var server Server
+		server, remapped = lb.pickServer(key)
+		return server
+	}
+
+	targetServer := lb.targetOverride(req)
 	if targetServer == nil {
-		http.Error(rw, "Service Unavailable", http.StatusServiceUnavailable)
+		targetServer = pick()
+	}
+	if targetServer == nil && lb.pickTimeout > 0 {
+		targetServer = lb.awaitServer(req, pick)
+	}
+	if targetServer == nil {
+		lb.writeError(rw, req, http.StatusServiceUnavailable)
 		return
 	}
+	lb.recordSelection(targetServer)
+
+	// Let the client know its usual backend was down, e.g. so it can re-establish session state
+	if remapped && lb.repinHeader != "" {
+		rw.Header().Set(lb.repinHeader, targetServer.Address())
+	}
+
+	// The proxy already aborts the upstream call when the client goes away, this also bounds how long
+	// it may take; a deadline the client brought along still applies if it is earlier
+	if lb.upstreamTimeout > 0 {
+		ctx, cancel := context.WithTimeout(req.Context(), lb.upstreamTimeout)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
+
 	log.Printf("Redirecting request from IP %s to server: %s", ip, targetServer.Address())
-	targetServer.Serve(rw, req)
+	lb.serveTimed(targetServer, rw, req)
+}
+
+// How often awaitServer retries picking a server while none is available
+const pickRetryInterval = 100 * time.Millisecond
+
+// awaitServer retries pick for up to pickTimeout, so a backend recovering meanwhile can still take
+// the request; it gives up early if the client goes away
+func (lb *loadBalancer) awaitServer(req *http.Request, pick func() Server) Server {
+	deadline := time.Now().Add(lb.pickTimeout)
+	for time.Now().Before(deadline) {
+		select {
+		case <-req.Context().Done():
+			return nil
+		case <-time.After(pickRetryInterval):
+		}
+		if server := pick(); server != nil {
+			return server
+		}
+	}
+	return nil
+}
+
+// targetOverride returns the backend named by the X-LB-Target header, when overrides are allowed
+// and that backend is alive; otherwise nil, and the request goes through the normal strategy
+func (lb *loadBalancer) targetOverride(req *http.Request) Server {
+	target := req.Header.Get("X-LB-Target")
+	if !lb.allowTargetOverride || target == "" {
+		return nil
+	}
+	for _, server := range lb.servers {
+		if server.Address() == target && server.IsAlive() {
+			return server
+		}
+	}
+	return nil
+}
+
+// A flight is one upstream request whose response is shared by identical requests arriving meanwhile
+type flight struct {
+	done   chan struct{}
+	status int
+	header http.Header
+	body   []byte
+}
+
+// responseRecorder buffers a response so it can be replayed to every waiting client
+type responseRecorder struct {
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.body.Write(b)
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func canCoalesce(req *http.Request) bool {
+	// Only safe methods, and never requests carrying credentials whose responses may be private
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	if req.Header.Get("Authorization") != "" || req.Header.Get("Proxy-Authorization") != "" || req.Header.Get("Cookie") != "" {
+		return false
+	}
+
+	// An upgrade takes over the connection, so its response can't be recorded and replayed
+	return req.Header.Get("Upgrade") == "" && !strings.Contains(strings.ToLower(req.Header.Get("Connection")), "upgrade")
+}
+
+// coalesceKey identifies the requests that may share a response: same target, and same
+// negotiation headers, so a client never gets a body encoded or formatted for another one
+func coalesceKey(req *http.Request) string {
+	key := req.Method + " " + req.Host + req.URL.RequestURI()
+	for _, name := range []string{"Accept", "Accept-Encoding", "Accept-Language"} {
+		key += "\n" + strings.Join(req.Header.Values(name), ",")
+	}
+	return key
+}
+
+func (lb *loadBalancer) serveCoalesced(rw http.ResponseWriter, req *http.Request) {
+	key := coalesceKey(req)
+	// Requests of different tenants never share a response, whichever backend they land on
+	if lb.affinityHeader != "" {
+		key += "\n" + req.Header.Get(lb.affinityHeader)
+	}
+
+	lb.flightsMutex.Lock()
+	if f, ok := lb.flights[key]; ok {
+		lb.flightsMutex.Unlock()
+
+		// Someone is already fetching this, wait for their response instead of hitting a backend
+		select {
+		case <-f.done:
+			f.writeTo(rw)
+		case <-req.Context().Done():
+		}
+		return
+	}
+	f := &flight{done: make(chan struct{})}
+	lb.flights[key] = f
+	lb.flightsMutex.Unlock()
+
+	lb.fly(key, f, req)
+	f.writeTo(rw)
+}
+
+// fly makes the upstream request of flight f and releases everyone waiting on it
+// The proxy panics with http.ErrAbortHandler when the upstream body fails partway; the flight is
+// still ended, with a 502 for the waiting requests, before the panic carries on to abort this one.
+func (lb *loadBalancer) fly(key string, f *flight, req *http.Request) {
+	recorder := &responseRecorder{header: make(http.Header)}
+	defer func() {
+		failure := recover()
+		if failure != nil {
+			recorder.status, recorder.header = http.StatusBadGateway, make(http.Header)
+			recorder.body.Reset()
+		}
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		f.status, f.header, f.body = recorder.status, recorder.header, recorder.body.Bytes()
+
+		// Later requests start a new flight, the waiting ones are released with this response
+		lb.flightsMutex.Lock()
+		delete(lb.flights, key)
+		lb.flightsMutex.Unlock()
+		close(f.done)
+
+		if failure != nil {
+			panic(failure)
+		}
+	}()
+
+	// The response is shared, so the leader's client going away must not abort it for the others;
+	// the upstream timeout still bounds the call
+	lb.forward(recorder, req.WithContext(context.WithoutCancel(req.Context())))
+}
+
+func (f *flight) writeTo(rw http.ResponseWriter) {
+	for key, values := range f.header {
+		rw.Header()[key] = append([]string(nil), values...)
+	}
+	rw.WriteHeader(f.status)
+	rw.Write(f.body)
+}
+
+// timingWriter records the status and time to first byte of a proxied response
+type timingWriter struct {
+	http.ResponseWriter
+	start     time.Time
+	firstByte time.Duration
+	status    int
+}
+
+func (w *timingWriter) markFirstByte() {
+	if w.firstByte == 0 {
+		w.firstByte = time.Since(w.start)
+	}
+}
+
+func (w *timingWriter) WriteHeader(status int) {
+	w.markFirstByte()
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *timingWriter) Write(b []byte) (int, error) {
+	w.markFirstByte()
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *timingWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, so the proxy can still
+// hijack the connection for protocol upgrades such as WebSockets
+func (w *timingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// enableSlowLog logs every request slower than threshold to path, or to stderr if path is empty
+func (lb *loadBalancer) enableSlowLog(threshold time.Duration, path string) {
+	out := io.Writer(os.Stderr)
+	if path != "" {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		handleErr(err)
+		out = file
+	}
+	lb.slowThreshold = threshold
+	lb.slowLog = log.New(out, "slow request: ", log.LstdFlags)
+}
+
+func (lb *loadBalancer) serveTimed(server Server, rw http.ResponseWriter, req *http.Request) {
+	if lb.slowLog == nil {
+		server.Serve(rw, req)
+		return
+	}
+
+	tw := &timingWriter{ResponseWriter: rw, start: time.Now()}
+	server.Serve(tw, req)
+	total := time.Since(tw.start)
+
+	if total >= lb.slowThreshold {
+		lb.slowLog.Printf("client=%s backend=%s method=%s uri=%s status=%d ttfb=%s total=%s",
+			req.RemoteAddr, server.Address(), req.Method, req.URL.RequestURI(), tw.status, tw.firstByte, total)
+	}
+}
+
+// Seconds a client is told to wait before retrying a request shed by admission control
+const retryAfterSeconds = "1"
+
+// limitConcurrency caps the number of requests being proxied at once
+// Up to maxQueue requests over the limit wait for a free slot, for at most queueTimeout each; the
+// rest, and those that time out, get a 503 with Retry-After. A maxQueue of 0 sheds straight away.
+func (lb *loadBalancer) limitConcurrency(limit int, maxQueue int, queueTimeout time.Duration) {
+	lb.admission = make(chan struct{}, limit)
+	lb.maxQueue = int64(maxQueue)
+	lb.queueTimeout = queueTimeout
+}
+
+// admit takes a slot for req, answering the client itself and returning false if it can't have one
+func (lb *loadBalancer) admit(rw http.ResponseWriter, req *http.Request) bool {
+	if lb.admission == nil {
+		return true
+	}
+
+	select {
+	case lb.admission <- struct{}{}:
+		return true
+	default:
+	}
+
+	if lb.joinQueue() {
+		defer lb.queueDepth.Add(-1)
+		timer := time.NewTimer(lb.queueTimeout)
+		defer timer.Stop()
+
+		select {
+		case lb.admission <- struct{}{}:
+			return true
+		case <-req.Context().Done():
+			// The client gave up while queued, there is nobody left to answer
+			return false
+		case <-timer.C:
+		}
+	}
+
+	rw.Header().Set("Retry-After", retryAfterSeconds)
+	lb.writeError(rw, req, http.StatusServiceUnavailable)
+	return false
+}
+
+// joinQueue takes a place in the admission queue, returning false if it is already full
+func (lb *loadBalancer) joinQueue() bool {
+	for {
+		depth := lb.queueDepth.Load()
+		if depth >= lb.maxQueue {
+			return false
+		}
+		if lb.queueDepth.CompareAndSwap(depth, depth+1) {
+			return true
+		}
+	}
+}
+
+func (lb *loadBalancer) release() {
+	if lb.admission != nil {
+		<-lb.admission
+	}
+}
+
+// Mirrored requests are fire-and-forget, so they get their own client and timeout
+var mirrorClient = &http.Client{
+	Timeout: 5 * time.Second,
+}
+
+func (lb *loadBalancer) addMirror(addr string) {
+	mirrorUrl, err := url.Parse(addr)
+	handleErr(err)
+	lb.mirrors = append(lb.mirrors, mirrorUrl)
+}
+
+// Largest request body buffered for the mirrors; a bigger one still reaches the primary, streamed as
+// usual, but the request isn't mirrored, so an upload never has to sit in memory first
+const maxMirrorBody = 1 << 20
+
+func (lb *loadBalancer) mirrorRequest(req *http.Request) {
+	if len(lb.mirrors) == 0 {
+		return
+	}
+
+	// Buffer the body so it can be replayed to the mirrors and still reach the primary
+	var body []byte
+	if req.Body != nil {
+		if req.ContentLength > maxMirrorBody {
+			log.Printf("Skipping mirror, request body of %d bytes is over %d bytes", req.ContentLength, maxMirrorBody)
+			return
+		}
+		b, err := io.ReadAll(io.LimitReader(req.Body, maxMirrorBody+1))
+		if err != nil || len(b) > maxMirrorBody {
+			if err != nil {
+				log.Printf("Skipping mirror, unable to buffer request body: %v", err)
+			} else {
+				log.Printf("Skipping mirror, request body is over %d bytes", maxMirrorBody)
+			}
+			req.Body = io.NopCloser(io.MultiReader(bytes.NewReader(b), req.Body))
+			return
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(b))
+		body = b
+	}
+
+	for _, mirror := range lb.mirrors {
+		go sendMirror(mirror, req.Method, req.URL.RequestURI(), req.Header.Clone(), body)
+	}
+}
+
+func sendMirror(mirror *url.URL, method string, uri string, header http.Header, body []byte) {
+	target := strings.TrimSuffix(mirror.String(), "/") + uri
+	req, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Mirror %s: unable to build request: %v", mirror, err)
+		return
+	}
+	req.Header = header
+
+	// Mirror responses are discarded, only the status code is logged
+	resp, err := mirrorClient.Do(req)
+	if err != nil {
+		log.Printf("Mirror %s: %s %s failed: %v", mirror, method, uri, err)
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	log.Printf("Mirror %s: %s %s returned %d", mirror, method, uri, resp.StatusCode)
+}
+
+const defaultMaintenancePage = `<html><body><h1>Down for maintenance</h1><p>Please try again shortly.</p></body></html>`
+
+type backendStatus struct {
+	Address           string `json:"address"`
+	Alive             bool   `json:"alive"`
+	Draining          bool   `json:"draining"`
+	CertExpiresInDays *int   `json:"certExpiresInDays,omitempty"`
+	RequestBytes      uint64 `json:"requestBytes"`
+	ResponseBytes     uint64 `json:"responseBytes"`
+}
+
+type adminStatus struct {
+	Maintenance bool            `json:"maintenance"`
+	Backends    []backendStatus `json:"backends"`
+	InFlight    int             `json:"inFlight"`
+	QueueDepth  int64           `json:"queueDepth"`
+	Remapped    int64           `json:"remapped"`
+}
+
+// The page shown to browsers when the balancer itself answers with an error
+const defaultErrorPage = `<html><body><h1>{{.Status}} {{.Text}}</h1><p>Please try again shortly.</p></body></html>`
+
+// The body sent to API clients when the balancer itself answers with an error
+func defaultErrorJSON(status int) interface{} {
+	return map[string]interface{}{"status": status, "error": http.StatusText(status)}
+}
+
+// writeError answers with status in the format the client asked for: JSON for API clients,
+// the error page for browsers, and plain text for everyone else
+func (lb *loadBalancer) writeError(rw http.ResponseWriter, req *http.Request, status int) {
+	accept := req.Header.Get("Accept")
+	jsonAt := strings.Index(accept, "json")
+	htmlAt := strings.Index(accept, "text/html")
+
+	switch {
+	case jsonAt >= 0 && (htmlAt < 0 || jsonAt < htmlAt):
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(status)
+		json.NewEncoder(rw).Encode(lb.errorJSON(status))
+	case htmlAt >= 0:
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+		rw.WriteHeader(status)
+		lb.errorPage.Execute(rw, map[string]interface{}{"Status": status, "Text": http.StatusText(status)})
+	default:
+		http.Error(rw, http.StatusText(status), status)
+	}
+}
+
+func (lb *loadBalancer) serveMaintenance(rw http.ResponseWriter) {
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.WriteHeader(http.StatusServiceUnavailable)
+	io.WriteString(rw, lb.maintenancePage)
+}
+
+func (lb *loadBalancer) handleMaintenance(rw http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		// Toggle with POST /maintenance?enabled=true|false
+		enabled, err := strconv.ParseBool(req.URL.Query().Get("enabled"))
+		if err != nil {
+			http.Error(rw, "enabled must be true or false", http.StatusBadRequest)
+			return
+		}
+		lb.maintenance.Store(enabled)
+		log.Printf("Maintenance mode set to %t", enabled)
+	default:
+		http.Error(rw, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(rw, map[string]bool{"maintenance": lb.maintenance.Load()})
+}
+
+func (lb *loadBalancer) handleDrain(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(rw, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Drain with POST /drain?backend=<address>&enabled=true, put it back with enabled=false
+	query := req.URL.Query()
+	enabled, err := strconv.ParseBool(query.Get("enabled"))
+	if err != nil {
+		http.Error(rw, "enabled must be true or false", http.StatusBadRequest)
+		return
+	}
+	for _, server := range lb.servers {
+		if server.Address() == query.Get("backend") {
+			server.SetDraining(enabled)
+			log.Printf("Draining of %s set to %t", server.Address(), enabled)
+			writeJSON(rw, map[string]bool{"draining": enabled})
+			return
+		}
+	}
+	http.Error(rw, "unknown backend", http.StatusNotFound)
+}
+
+func (lb *loadBalancer) handleBackends(rw http.ResponseWriter, req *http.Request) {
+	status := adminStatus{
+		Maintenance: lb.maintenance.Load(),
+		InFlight:    len(lb.admission),
+		QueueDepth:  lb.queueDepth.Load(),
+		Remapped:    lb.remapped.Load(),
+		Backends:    []backendStatus{},
+	}
+	for _, server := range lb.servers {
+		status.Backends = append(status.Backends, backendStatus{
+			Address:           server.Address(),
+			Alive:             server.IsAlive(),
+			Draining:          server.IsDraining(),
+			CertExpiresInDays: certExpiresInDays(server),
+			RequestBytes:      server.RequestBytes(),
+			ResponseBytes:     server.ResponseBytes(),
+		})
+	}
+	writeJSON(rw, status)
+}
+
+type selectionCount struct {
+	Address    string  `json:"address"`
+	Weight     float64 `json:"weight"`
+	Selections int     `json:"selections"`
+	Share      float64 `json:"share"`
+}
+
+type fairnessSummary struct {
+	Total int `json:"total"`
+	// Coefficient of variation of selections per unit of weight: 0 means perfectly balanced
+	CoefficientOfVariation float64          `json:"coefficientOfVariation"`
+	Backends               []selectionCount `json:"backends"`
+}
+
+func (lb *loadBalancer) recordSelection(server Server) {
+	lb.selectionsMutex.Lock()
+	defer lb.selectionsMutex.Unlock()
+	lb.selections[server.Address()]++
+}
+
+func (lb *loadBalancer) fairness() fairnessSummary {
+	lb.selectionsMutex.Lock()
+	defer lb.selectionsMutex.Unlock()
+
+	summary := fairnessSummary{Backends: []selectionCount{}}
+	for _, server := range lb.servers {
+		count := lb.selections[server.Address()]
+		summary.Total += count
+		summary.Backends = append(summary.Backends, selectionCount{
+			Address:    server.Address(),
+			Weight:     selectionWeight(server),
+			Selections: count,
+		})
+	}
+	if summary.Total == 0 || len(summary.Backends) == 0 {
+		return summary
+	}
+
+	// Normalise by weight so a weighted pool that tracks its weights also scores 0
+	var mean float64
+	for i, backend := range summary.Backends {
+		summary.Backends[i].Share = float64(backend.Selections) / float64(summary.Total)
+		mean += float64(backend.Selections) / backend.Weight
+	}
+	mean /= float64(len(summary.Backends))
+
+	var variance float64
+	for _, backend := range summary.Backends {
+		diff := float64(backend.Selections)/backend.Weight - mean
+		variance += diff * diff
+	}
+	variance /= float64(len(summary.Backends))
+	summary.CoefficientOfVariation = math.Sqrt(variance) / mean
+	return summary
+}
+
+// ResetStats rebaselines the collected statistics without interrupting in-flight requests
+func (lb *loadBalancer) ResetStats() {
+	lb.selectionsMutex.Lock()
+	lb.selections = make(map[string]int)
+	lb.selectionsMutex.Unlock()
+	for _, server := range lb.servers {
+		server.ResetStats()
+	}
+	lb.remapped.Store(0)
+	log.Println("Statistics reset")
+}
+
+func (lb *loadBalancer) handleResetStats(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(rw, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	lb.ResetStats()
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (lb *loadBalancer) handleFairness(rw http.ResponseWriter, req *http.Request) {
+	writeJSON(rw, lb.fairness())
+}
+
+// Every backend is expected to receive an equal share of selections
+func selectionWeight(server Server) float64 {
+	return 1
+}
+
+func writeJSON(rw http.ResponseWriter, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(v); err != nil {
+		log.Printf("Unable to write admin response: %v", err)
+	}
+}
+
+// The admin API listens on its own port so it never collides with proxied paths
+func (lb *loadBalancer) serveAdmin(port string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/maintenance", lb.handleMaintenance)
+	mux.HandleFunc("/backends", lb.handleBackends)
+	mux.HandleFunc("/drain", lb.handleDrain)
+	mux.HandleFunc("/fairness", lb.handleFairness)
+	mux.HandleFunc("/stats/reset", lb.handleResetStats)
+
+	log.Printf("Admin API serving at localhost:%s", port)
+	err := http.ListenAndServe(":"+port, mux)
+	handleErr(err)
 }
 
 func main() {
+	// To demo without the public sites, run testBackend.go on ports 9101-9103 and use http://localhost:9101 etc.
 	servers := []Server{
 		newSimpleServer("https://www.facebook.com"),
 		newSimpleServer("http://www.bing.com"),
@@ -105,11 +1193,45 @@ func main() {
 	}
 
 	lb := newLoadBalancer("8000", servers)
+	// Uncomment to send a copy of every request to a shadow backend (bodies over 1MB are not mirrored)
+	// lb.addMirror("http://localhost:9000")
+	// Uncomment to let identical concurrent GETs share one upstream request
+	// lb.coalesce = true
+	// Uncomment to log requests slower than one second to slow.log
+	// lb.enableSlowLog(time.Second, "slow.log")
+	// Uncomment to proxy at most 100 requests at once, queueing up to 500 more for at most 5 seconds each
+	// lb.limitConcurrency(100, 500, 5*time.Second)
+	// Uncomment to give up on upstream calls that take longer than 30 seconds
+	// lb.upstreamTimeout = 30 * time.Second
+	// Uncomment to wait up to 2 seconds for a backend to recover before answering 503
+	// lb.pickTimeout = 2 * time.Second
+	// Uncomment to let clients pin a request to a backend with X-LB-Target (debugging only, never in production)
+	// lb.allowTargetOverride = true
+	// Uncomment to route every request of a tenant to the same backend, falling back to the client IP
+	// lb.affinityHeader = "X-Tenant-ID"
+	// Uncomment to keep clients on the backend they were moved to when theirs went down, instead of moving them back
+	// lb.stickyRepin = true
+	// Uncomment to tell clients which backend they were moved to when theirs is down
+	// lb.repinHeader = "X-LB-Repinned"
+	// Uncomment to trust a private CA for the first backend (set CertFile and KeyFile for mTLS)
+	// handleErr(servers[0].(*simpleServer).configureTLS(upstreamTLS{CAFile: "ca.pem"}))
+	// Uncomment to warn when the first backend's certificate expires within two weeks, and stop using it then
+	// servers[0].(*simpleServer).certExpiryWarning = 14 * 24 * time.Hour
+	// servers[0].(*simpleServer).failOnCertExpiry = true
+	// Uncomment to stop sending traffic to a backend as soon as a connection to it fails
+	// for _, server := range servers {
+	// 	server.(*simpleServer).enablePassiveHealth()
+	// }
+	// Uncomment to send an API key to the second backend and hide its Server header from clients
+	// servers[1].(*simpleServer).rewriteHeaders(headerRules{SetRequest: map[string]string{"X-Api-Key": "secret"}, RemoveResponse: []string{"Server"}})
+
 	handleRedirect := func(rw http.ResponseWriter, req *http.Request) {
 		lb.serveProxy(rw, req)
 	}
 	http.HandleFunc("/", handleRedirect)
 
+	go lb.serveAdmin("8001")
+
 	log.Printf("Load Balancer serving at localhost:%s", lb.port)
 	err := http.ListenAndServe(":"+lb.port, nil)
 	handleErr(err)
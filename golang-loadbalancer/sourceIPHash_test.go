@@ -0,0 +1,211 @@
+package main
+
+// The balancers are standalone programs, so test each one together with its own file:
+//
+//	go test sourceIPHash.go sourceIPHash_test.go
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestBackend starts a backend answering every request with its name
+func newTestBackend(t *testing.T, name string) *httptest.Server {
+	t.Helper()
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(rw, name)
+	}))
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+// send proxies one request from remoteAddr through lb and returns the response
+func send(lb *loadBalancer, remoteAddr string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = remoteAddr
+	rec := httptest.NewRecorder()
+	lb.serveProxy(rec, req)
+	return rec
+}
+
+func TestStickyRepinAfterBackendDies(t *testing.T) {
+	backends := map[string]*httptest.Server{}
+	var servers []Server
+	for _, name := range []string{"A", "B", "C", "D"} {
+		backends[name] = newTestBackend(t, name)
+		servers = append(servers, newSimpleServer(backends[name].URL))
+	}
+	lb := newLoadBalancer("0", servers)
+	lb.stickyRepin = true
+	lb.repinHeader = "X-LB-Repinned"
+
+	home := send(lb, "10.0.0.1:40000").Body.String()
+	backends[home].Close()
+
+	// Every request comes from a new connection, so a new port, but the client stays one client
+	var pinned string
+	for i := 0; i < 20; i++ {
+		rec := send(lb, fmt.Sprintf("10.0.0.1:%d", 40001+i))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want %d", i, rec.Code, http.StatusOK)
+		}
+		got := rec.Body.String()
+		if got == home {
+			t.Fatalf("request %d went to %s, which is down", i, home)
+		}
+		if pinned == "" {
+			pinned = got
+			if rec.Header().Get("X-LB-Repinned") == "" {
+				t.Errorf("first request after %s died has no X-LB-Repinned header", home)
+			}
+		} else if got != pinned {
+			t.Fatalf("request %d went to %s, want it to stay on %s", i, got, pinned)
+		}
+	}
+
+	if len(lb.pins) != 1 {
+		t.Fatalf("%d pins for one client, want 1", len(lb.pins))
+	}
+}
+
+func TestSourceIPIgnoresPort(t *testing.T) {
+	var servers []Server
+	for _, name := range []string{"A", "B", "C", "D"} {
+		servers = append(servers, newSimpleServer(newTestBackend(t, name).URL))
+	}
+	lb := newLoadBalancer("0", servers)
+
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2", "192.168.1.7"} {
+		first := send(lb, ip+":50000").Body.String()
+		for port := 50001; port < 50010; port++ {
+			if got := send(lb, fmt.Sprintf("%s:%d", ip, port)).Body.String(); got != first {
+				t.Fatalf("%s:%d went to %s, want %s like the client's other connections", ip, port, got, first)
+			}
+		}
+	}
+}
+
+func TestPinsAreBounded(t *testing.T) {
+	lb := newLoadBalancer("0", []Server{newSimpleServer("http://localhost:1")})
+	for i := 0; i < maxPins+10; i++ {
+		lb.pin(fmt.Sprint(i), 0)
+	}
+	if len(lb.pins) > maxPins {
+		t.Fatalf("%d pins, want at most %d", len(lb.pins), maxPins)
+	}
+
+	// Expired pins make room for new ones
+	for key, p := range lb.pins {
+		p.expires = p.expires.Add(-2 * pinTTL)
+		lb.pins[key] = p
+	}
+	lb.pin("new", 0)
+	if _, ok := lb.pins["new"]; !ok || len(lb.pins) != 1 {
+		t.Fatalf("pinning after every pin expired left %d pins, want only the new one", len(lb.pins))
+	}
+}
+
+func TestAffinityHeader(t *testing.T) {
+	var servers []Server
+	for _, name := range []string{"A", "B", "C", "D"} {
+		servers = append(servers, newSimpleServer(newTestBackend(t, name).URL))
+	}
+	lb := newLoadBalancer("0", servers)
+	lb.affinityHeader = "X-Tenant-ID"
+
+	// Each tenant is served from many addresses, and always lands on the same backend
+	for _, tenant := range []string{"acme", "globex", "initech"} {
+		var home string
+		for i := 0; i < 20; i++ {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = fmt.Sprintf("10.0.%d.%d:50000", i/10, i)
+			req.Header.Set("X-Tenant-ID", tenant)
+			rec := httptest.NewRecorder()
+			lb.serveProxy(rec, req)
+
+			got := rec.Body.String()
+			if home == "" {
+				home = got
+			} else if got != home {
+				t.Fatalf("tenant %s from %s went to %s, want %s like its other requests", tenant, req.RemoteAddr, got, home)
+			}
+		}
+	}
+}
+
+func TestRemapChurn(t *testing.T) {
+	backends := map[string]*httptest.Server{}
+	var servers []Server
+	for _, name := range []string{"A", "B", "C", "D"} {
+		backends[name] = newTestBackend(t, name)
+		servers = append(servers, newSimpleServer(backends[name].URL))
+	}
+	lb := newLoadBalancer("0", servers)
+
+	clients := 100
+	homes := make([]string, clients)
+	for i := range homes {
+		homes[i] = send(lb, fmt.Sprintf("10.1.0.%d:40000", i)).Body.String()
+	}
+	if lb.remapped.Load() != 0 {
+		t.Fatalf("remapped = %d with every backend up, want 0", lb.remapped.Load())
+	}
+
+	// Only the clients homed on the failed backend move, everyone else stays put
+	failed := homes[0]
+	backends[failed].Close()
+	moved := 0
+	for i, home := range homes {
+		got := send(lb, fmt.Sprintf("10.1.0.%d:40001", i)).Body.String()
+		switch {
+		case home == failed && got == failed:
+			t.Fatalf("client %d still went to %s, which is down", i, failed)
+		case home == failed:
+			moved++
+		case got != home:
+			t.Fatalf("client %d moved from %s to %s, though %s was the backend that failed", i, home, got, failed)
+		}
+	}
+	if got := lb.remapped.Load(); got != int64(moved) {
+		t.Fatalf("remapped = %d, want %d, the clients homed on %s", got, moved, failed)
+	}
+}
+
+func TestCoalesceKeepsTenantsApart(t *testing.T) {
+	// A slow backend answering with the tenant it served, so the requests overlap
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		fmt.Fprint(rw, req.Header.Get("X-Tenant-ID"))
+	}))
+	t.Cleanup(backend.Close)
+
+	lb := newLoadBalancer("0", []Server{newSimpleServer(backend.URL)})
+	lb.affinityHeader = "X-Tenant-ID"
+	lb.coalesce = true
+
+	tenants := []string{"acme", "globex", "acme", "globex"}
+	bodies := make([]string, len(tenants))
+	var wg sync.WaitGroup
+	for i, tenant := range tenants {
+		wg.Add(1)
+		go func(i int, tenant string) {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodGet, "/report", nil)
+			req.Header.Set("X-Tenant-ID", tenant)
+			rec := httptest.NewRecorder()
+			lb.serveProxy(rec, req)
+			bodies[i] = rec.Body.String()
+		}(i, tenant)
+	}
+	wg.Wait()
+
+	for i, tenant := range tenants {
+		if bodies[i] != tenant {
+			t.Errorf("request %d of tenant %s got the response for %q", i, tenant, bodies[i])
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// A tiny backend for exercising the load balancers locally, run one per port:
+//
+//	go run testBackend.go -name A -port 9101
+//	go run testBackend.go -name B -port 9102
+//	go run testBackend.go -name C -port 9103 -latency 50ms
+//
+// and point the balancer's servers at http://localhost:9101, :9102 and :9103.
+func main() {
+	name := flag.String("name", "backend", "name returned in every response")
+	port := flag.String("port", "9101", "port to listen on")
+	latency := flag.Duration("latency", 0, "artificial delay added to every response")
+	flag.Parse()
+
+	http.HandleFunc("/", func(rw http.ResponseWriter, req *http.Request) {
+		time.Sleep(*latency)
+		fmt.Fprintf(rw, "%s %s\n", *name, req.URL.Path)
+	})
+
+	log.Printf("Test backend %s serving at localhost:%s", *name, *port)
+	err := http.ListenAndServe(":"+*port, nil)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+}
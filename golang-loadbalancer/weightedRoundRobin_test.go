@@ -0,0 +1,311 @@
+package main
+
+// The balancers are standalone programs, so test each one together with its own file:
+//
+//	go test weightedRoundRobin.go weightedRoundRobin_test.go
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestBackend starts a backend answering every request with its name
+func newTestBackend(t *testing.T, name string) *httptest.Server {
+	t.Helper()
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(rw, name)
+	}))
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+func TestPickServerAllDraining(t *testing.T) {
+	servers := []Server{
+		newSimpleServer(newTestBackend(t, "A").URL, 5),
+		newSimpleServer(newTestBackend(t, "B").URL, 3),
+	}
+	for _, server := range servers {
+		server.SetDraining(true)
+	}
+	lb := newLoadBalancer("0", servers)
+
+	picked := make(chan Server, 1)
+	go func() { picked <- lb.pickServer() }()
+	select {
+	case server := <-picked:
+		if server != nil {
+			t.Fatalf("pickServer() = %s, want nil with every server draining", server.Address())
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("pickServer() did not return with every server draining")
+	}
+
+	rec := httptest.NewRecorder()
+	lb.serveProxy(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestPickServerSkipsDraining(t *testing.T) {
+	servers := []Server{
+		newSimpleServer(newTestBackend(t, "A").URL, 5),
+		newSimpleServer(newTestBackend(t, "B").URL, 3),
+	}
+	servers[0].SetDraining(true)
+	lb := newLoadBalancer("0", servers)
+
+	for i := 0; i < 10; i++ {
+		if server := lb.pickServer(); server != servers[1] {
+			t.Fatalf("pick %d went to %v, want the live server %s", i, server, servers[1].Address())
+		}
+	}
+}
+
+func TestSlowLogKeepsUpgrades(t *testing.T) {
+	// A backend that accepts the upgrade and echoes one line back over the raw connection
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Upgrade") != "echo" {
+			fmt.Fprint(rw, "ok")
+			return
+		}
+		conn, brw, err := http.NewResponseController(rw).Hijack()
+		if err != nil {
+			t.Errorf("backend hijack: %v", err)
+			return
+		}
+		defer conn.Close()
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		brw.Flush()
+		line, _ := brw.ReadString('\n')
+		brw.WriteString(line)
+		brw.Flush()
+	}))
+	t.Cleanup(backend.Close)
+
+	lb := newLoadBalancer("0", []Server{newSimpleServer(backend.URL, 1)})
+	lb.enableSlowLog(0, "")
+	lb.slowLog.SetOutput(io.Discard)
+	front := httptest.NewServer(http.HandlerFunc(lb.serveProxy))
+	t.Cleanup(front.Close)
+
+	conn, err := net.Dial("tcp", front.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: lb\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusSwitchingProtocols)
+	}
+	fmt.Fprint(conn, "hello\n")
+	if line, err := reader.ReadString('\n'); err != nil || line != "hello\n" {
+		t.Fatalf("echo = %q, %v, want %q", line, err, "hello\n")
+	}
+}
+
+func TestCanCoalesce(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		header map[string]string
+		want   bool
+	}{
+		{"plain GET", http.MethodGet, nil, true},
+		{"HEAD", http.MethodHead, nil, true},
+		{"POST", http.MethodPost, nil, false},
+		{"authorization", http.MethodGet, map[string]string{"Authorization": "Bearer x"}, false},
+		{"proxy authorization", http.MethodGet, map[string]string{"Proxy-Authorization": "Basic x"}, false},
+		{"cookie", http.MethodGet, map[string]string{"Cookie": "session=1"}, false},
+		{"upgrade", http.MethodGet, map[string]string{"Connection": "Upgrade", "Upgrade": "websocket"}, false},
+		{"connection upgrade only", http.MethodGet, map[string]string{"Connection": "keep-alive, Upgrade"}, false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "/", nil)
+		for key, value := range tt.header {
+			req.Header.Set(key, value)
+		}
+		if got := canCoalesce(req); got != tt.want {
+			t.Errorf("%s: canCoalesce() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCoalesceKeepsNegotiatedBodiesApart(t *testing.T) {
+	// A slow backend answering with the Accept header it was sent, so requests overlap
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		fmt.Fprint(rw, req.Header.Get("Accept"))
+	}))
+	t.Cleanup(backend.Close)
+
+	lb := newLoadBalancer("0", []Server{newSimpleServer(backend.URL, 1)})
+	lb.coalesce = true
+
+	accepts := []string{"application/json", "text/html", "application/json", "text/html"}
+	bodies := make([]string, len(accepts))
+	var wg sync.WaitGroup
+	for i, accept := range accepts {
+		wg.Add(1)
+		go func(i int, accept string) {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodGet, "/hot", nil)
+			req.Header.Set("Accept", accept)
+			rec := httptest.NewRecorder()
+			lb.serveProxy(rec, req)
+			bodies[i] = rec.Body.String()
+		}(i, accept)
+	}
+	wg.Wait()
+
+	for i, accept := range accepts {
+		if bodies[i] != accept {
+			t.Errorf("request %d with Accept %q got body %q", i, accept, bodies[i])
+		}
+	}
+}
+
+func TestCoalesceSurvivesTruncatedBody(t *testing.T) {
+	// The first proxied response promises more body than it sends before the backend hangs up
+	var requests atomic.Int64
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/hot" || requests.Add(1) > 1 {
+			fmt.Fprint(rw, "ok")
+			return
+		}
+		rw.Header().Set("Content-Length", "100")
+		fmt.Fprint(rw, "short")
+		http.NewResponseController(rw).Flush()
+		if conn, _, err := http.NewResponseController(rw).Hijack(); err == nil {
+			conn.Close()
+		}
+	}))
+	t.Cleanup(backend.Close)
+
+	lb := newLoadBalancer("0", []Server{newSimpleServer(backend.URL, 1)})
+	lb.coalesce = true
+	front := httptest.NewServer(http.HandlerFunc(lb.serveProxy))
+	t.Cleanup(front.Close)
+	client := &http.Client{Timeout: 2 * time.Second}
+
+	if resp, err := client.Get(front.URL + "/hot"); err == nil {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	// The failed flight must not be left behind for the next identical request to wait on
+	resp, err := client.Get(front.URL + "/hot")
+	if err != nil {
+		t.Fatalf("request after a truncated response: %v", err)
+	}
+	defer resp.Body.Close()
+	if body, _ := io.ReadAll(resp.Body); resp.StatusCode != http.StatusOK || string(body) != "ok" {
+		t.Fatalf("status %d with body %q, want 200 with %q", resp.StatusCode, body, "ok")
+	}
+}
+
+func TestCoalesceOutlivesLeader(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/hot" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		fmt.Fprint(rw, "hot")
+	}))
+	t.Cleanup(backend.Close)
+
+	lb := newLoadBalancer("0", []Server{newSimpleServer(backend.URL, 1)})
+	lb.coalesce = true
+
+	// The leader's client gives up while the shared upstream request is still running
+	ctx, cancel := context.WithCancel(context.Background())
+	leaderDone := make(chan struct{})
+	go func() {
+		defer close(leaderDone)
+		lb.serveProxy(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/hot", nil).WithContext(ctx))
+	}()
+	for {
+		lb.flightsMutex.Lock()
+		n := len(lb.flights)
+		lb.flightsMutex.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	follower := make(chan *httptest.ResponseRecorder)
+	go func() {
+		rec := httptest.NewRecorder()
+		lb.serveProxy(rec, httptest.NewRequest(http.MethodGet, "/hot", nil))
+		follower <- rec
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	if rec := <-follower; rec.Code != http.StatusOK || rec.Body.String() != "hot" {
+		t.Fatalf("follower got status %d with body %q after the leader left, want 200 with %q", rec.Code, rec.Body.String(), "hot")
+	}
+	<-leaderDone
+}
+
+func TestAdmitBoundsQueue(t *testing.T) {
+	lb := newLoadBalancer("0", nil)
+	lb.limitConcurrency(1, 1, 200*time.Millisecond)
+	lb.admission <- struct{}{} // The only slot is taken
+
+	// The first request over the limit queues, and gives up at the queue timeout
+	queued := make(chan *httptest.ResponseRecorder)
+	go func() {
+		rec := httptest.NewRecorder()
+		lb.admit(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		queued <- rec
+	}()
+	for lb.queueDepth.Load() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The queue is full, so the next one is shed straight away
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	if lb.admit(rec, httptest.NewRequest(http.MethodGet, "/", nil)) {
+		t.Fatal("admitted a request with the slot taken and the queue full")
+	}
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("shed request got status %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("shedding took %s, want no wait with the queue full", elapsed)
+	}
+
+	rec = <-queued
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("timed out request got status %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if depth := lb.queueDepth.Load(); depth != 0 {
+		t.Fatalf("queue depth = %d after the queue emptied, want 0", depth)
+	}
+
+	// A queued request gets the slot as soon as it is released
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		lb.release()
+	}()
+	if !lb.admit(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil)) {
+		t.Fatal("queued request was not admitted when the slot was released")
+	}
+}